@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Backend covers everything the dock needs from the compositor: listing tasks, following
+window and workspace events, and acting upon windows. The GTK code only talks to the
+compositor through the global `backend` variable, so that other compositors (or a fake
+one) may be plugged in.
+*/
+type Backend interface {
+	// ListTasks returns tasks sorted by workspace numbers, and the focused workspace name
+	ListTasks() ([]task, string, error)
+	// Outputs returns outputs with their logical positions, to map them onto gdk monitors
+	Outputs() ([]output, error)
+	// Workspaces returns existing workspaces, numbered ones first, in the order of numbers
+	Workspaces() ([]workspace, error)
+	// TaskEvents streams window changes until the context gets cancelled
+	TaskEvents(ctx context.Context) (<-chan TaskChange, error)
+	// WorkspaceEvents streams the name of the focused workspace until the context gets cancelled
+	WorkspaceEvents(ctx context.Context) (<-chan string, error)
+
+	// Supports tells if the optional feature is available; unsupported methods return errNotSupported
+	Supports(f feature) bool
+
+	FocusCon(conID int64) error
+	KillCon(conID int64) error
+	MoveConToWorkspace(conID int64, wsNum int) error
+	FocusWorkspace(name string) error
+	SetMinimized(conID int64, minimized bool) error
+	SetMaximized(conID int64, maximized bool) error
+}
+
+type feature int
+
+const (
+	featureWorkspaces feature = iota
+	featureMinimize
+	featureMaximize
+	// minimized windows go to the sway scratchpad
+	featureScratchpad
+)
+
+var errNotSupported = errors.New("not supported by the compositor backend")
+
+type output struct {
+	Name string `json:"name"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+}
+
+// Num is 0 for workspaces w/o a number, e.g. "web"; Name holds the whole name, e.g. "3:mail"
+type workspace struct {
+	Num     int64  `json:"num"`
+	Name    string `json:"name"`
+	Output  string `json:"output"`
+	Focused bool   `json:"focused,omitempty"`
+	Visible bool   `json:"visible,omitempty"`
+	Urgent  bool   `json:"urgent,omitempty"`
+}
+
+// numbered workspaces go first, by their numbers, and then named ones, by names
+func sortWorkspaces(workspaces []workspace) {
+	sort.SliceStable(workspaces, func(i, j int) bool {
+		wi, wj := workspaces[i], workspaces[j]
+		if (wi.Num > 0) != (wj.Num > 0) {
+			return wi.Num > 0
+		}
+		if wi.Num != wj.Num {
+			return wi.Num < wj.Num
+		}
+		return wi.Name < wj.Name
+	})
+}
+
+var backend Backend
+
+var disconnected atomic.Bool
+
+// guards state changes, so that the drain and the send below can't interleave
+var connectionMu sync.Mutex
+
+/*
+Backends report here whether the compositor IPC works. The GTK side receives state changes
+from connectionStateChannel; only the latest state matters, so a stale one gets replaced.
+Never blocks, so it's safe to call from anywhere, but not while holding backend locks the
+receiving side may be waiting for.
+*/
+func setConnected(connected bool) {
+	connectionMu.Lock()
+	defer connectionMu.Unlock()
+
+	if disconnected.Load() == !connected {
+		return
+	}
+	disconnected.Store(!connected)
+	if connected {
+		log.Info("Connected to the compositor")
+	} else {
+		log.Warn("Disconnected from the compositor")
+	}
+	select {
+	case <-connectionStateChannel:
+	default:
+	}
+	select {
+	case connectionStateChannel <- connected:
+	default:
+	}
+}
+
+// returns the backend chosen with the -b flag, or the one matching the running compositor
+func newBackend(name string) (Backend, error) {
+	if name == "" {
+		if os.Getenv("HYPRLAND_INSTANCE_SIGNATURE") != "" {
+			name = "hyprland"
+		} else if os.Getenv("SWAYSOCK") != "" {
+			name = "sway"
+		} else {
+			name = "wlr"
+		}
+	}
+
+	switch name {
+	case "sway":
+		return newSwayBackend(), nil
+	case "hyprland":
+		return newHyprlandBackend(), nil
+	case "wlr":
+		return newWlrBackend()
+	}
+	return nil, fmt.Errorf("unknown backend: %s", name)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// fakeBackend serves canned tasks and workspaces, and records the actions requested, instead of talking to a compositor
+type fakeBackend struct {
+	mu         sync.Mutex
+	tasks      []task
+	wsName     string
+	workspaces []workspace
+	outputs    []output
+	features   []feature
+	actions    []string
+}
+
+func (b *fakeBackend) ListTasks() ([]task, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]task(nil), b.tasks...), b.wsName, nil
+}
+
+func (b *fakeBackend) Outputs() ([]output, error) {
+	return b.outputs, nil
+}
+
+func (b *fakeBackend) Workspaces() ([]workspace, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]workspace(nil), b.workspaces...), nil
+}
+
+// no events; the tests change the state directly
+func (b *fakeBackend) TaskEvents(ctx context.Context) (<-chan TaskChange, error) {
+	return make(chan TaskChange), nil
+}
+
+func (b *fakeBackend) WorkspaceEvents(ctx context.Context) (<-chan string, error) {
+	return make(chan string), nil
+}
+
+func (b *fakeBackend) Supports(f feature) bool {
+	return slices.Contains(b.features, f)
+}
+
+func (b *fakeBackend) action(action string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.actions = append(b.actions, action)
+	return nil
+}
+
+func (b *fakeBackend) FocusCon(conID int64) error {
+	return b.action(fmt.Sprintf("focus %v", conID))
+}
+
+func (b *fakeBackend) KillCon(conID int64) error {
+	return b.action(fmt.Sprintf("kill %v", conID))
+}
+
+func (b *fakeBackend) MoveConToWorkspace(conID int64, wsNum int) error {
+	return b.action(fmt.Sprintf("move %v to workspace %v", conID, wsNum))
+}
+
+func (b *fakeBackend) FocusWorkspace(name string) error {
+	return b.action(fmt.Sprintf("focus workspace %s", name))
+}
+
+func (b *fakeBackend) SetMinimized(conID int64, minimized bool) error {
+	if !b.Supports(featureMinimize) {
+		return errNotSupported
+	}
+	return b.action(fmt.Sprintf("set %v minimized=%v", conID, minimized))
+}
+
+func (b *fakeBackend) SetMaximized(conID int64, maximized bool) error {
+	if !b.Supports(featureMaximize) {
+		return errNotSupported
+	}
+	return b.action(fmt.Sprintf("set %v maximized=%v", conID, maximized))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+/*
+Pinned and task buttons may be dragged onto each other to change their order, and land before or after the
+button they're dropped onto, depending on which half of it. Dropping onto a pinned item moves the dragged one
+among pinned items (and pins it, if not pinned yet), and the new order gets saved. Unpinned tasks may be
+reordered among themselves, for the current session.
+*/
+
+// we only pass item keys within the dock
+const dragTarget = "text/plain"
+
+// keys of unpinned tasks in the order set by dragging; nil until the first drag, to keep the workspace order
+var taskOrder []string
+
+func setupDragAndDrop(button *gtk.Button, key string) {
+	target, err := gtk.TargetEntryNew(dragTarget, gtk.TARGET_SAME_APP, 0)
+	if err != nil {
+		log.Warnf("Unable to set up drag and drop: %s", err)
+		return
+	}
+	targets := []gtk.TargetEntry{*target}
+	button.DragSourceSet(gdk.BUTTON1_MASK, targets, gdk.ACTION_MOVE)
+	button.DragDestSet(gtk.DEST_DEFAULT_ALL, targets, gdk.ACTION_MOVE)
+
+	button.Connect("drag-begin", func(btn *gtk.Button, context *gdk.DragContext) {
+		if image, err := btn.GetImage(); err == nil && image != nil {
+			if img, ok := image.(*gtk.Image); ok && img.GetPixbuf() != nil {
+				gtk.DragSetIconPixbuf(context, img.GetPixbuf(), imgSizeScaled/2, imgSizeScaled/2)
+			}
+		}
+	})
+
+	button.Connect("drag-data-get", func(btn *gtk.Button, context *gdk.DragContext, data *gtk.SelectionData) {
+		data.SetText(key)
+	})
+
+	button.Connect("drag-data-received", func(btn *gtk.Button, context *gdk.DragContext, x, y int, data *gtk.SelectionData) {
+		after := x > btn.GetAllocatedWidth()/2
+		if innerOrientation == gtk.ORIENTATION_VERTICAL {
+			after = y > btn.GetAllocatedHeight()/2
+		}
+		moveItem(data.GetText(), key, after)
+	})
+}
+
+// moves the src item before or after the dest one
+func moveItem(src, dest string, after bool) {
+	if src == "" || sameGroup(src, dest) {
+		return
+	}
+
+	if inPinned(dest) {
+		savePinned(func(pins []*pin) []*pin {
+			p := &pin{ID: src}
+			if idx := slices.IndexFunc(pins, func(p *pin) bool { return sameGroup(p.ID, src) }); idx != -1 {
+				p = pins[idx]
+				pins = slices.Delete(pins, idx, idx+1)
+			}
+			// the file may have changed since the rebuild
+			idx := slices.IndexFunc(pins, func(p *pin) bool { return sameGroup(p.ID, dest) })
+			if idx == -1 {
+				idx = len(pins)
+			} else if after {
+				idx++
+			}
+			return slices.Insert(pins, idx, p)
+		})
+		return
+	}
+
+	if inPinned(src) {
+		log.Debugf("Not moving pinned '%s' among unpinned tasks", src)
+		return
+	}
+
+	order := slices.DeleteFunc(unpinnedKeys(visibleTasks(taskList.list())), func(key string) bool {
+		return key == src
+	})
+	idx := slices.Index(order, dest)
+	if idx == -1 {
+		return
+	}
+	if after {
+		idx++
+	}
+	taskOrder = slices.Insert(order, idx, src)
+	refreshMainBoxChannel <- struct{}{}
+}
+
+// keys of unpinned tasks, in the order of workspace numbers, or the one set by dragging
+func unpinnedKeys(tasks []task) []string {
+	var keys []string
+	for _, t := range tasks {
+		if key := groupKey(t); !inPinned(key) && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	if taskOrder != nil {
+		// tasks that came after the last drag go to the end
+		position := func(key string) int {
+			if idx := slices.Index(taskOrder, key); idx != -1 {
+				return idx
+			}
+			return len(taskOrder)
+		}
+		slices.SortStableFunc(keys, func(a, b string) int {
+			return cmp.Compare(position(a), position(b))
+		})
+	}
+	return keys
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUnpinnedKeys(t *testing.T) {
+	setGlobal(t, &pinned, []*pin{{ID: "firefox"}})
+	setGlobal(t, &groupRules, nil)
+	setGlobal(t, &taskOrder, nil)
+
+	tasks := []task{
+		{conID: 1, ID: "foot", WsNum: 1},
+		{conID: 2, ID: "firefox", WsNum: 1},
+		{conID: 3, ID: "gimp", WsNum: 2},
+		{conID: 4, ID: "foot", WsNum: 3},
+		{conID: 5, ID: "mpv", WsNum: 3},
+	}
+
+	// pinned ones are left out, and each group comes once, in the workspace order
+	if keys := unpinnedKeys(tasks); !equalStrings(keys, "foot", "gimp", "mpv") {
+		t.Errorf("keys = %v", keys)
+	}
+
+	// the order set by dragging wins; tasks not known then go to the end
+	taskOrder = []string{"gimp", "foot"}
+	if keys := unpinnedKeys(tasks); !equalStrings(keys, "gimp", "foot", "mpv") {
+		t.Errorf("keys after dragging = %v", keys)
+	}
+}
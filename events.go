@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// subscribers retry failed subscriptions after the delay
+const subscribeRetryDelay = 2 * time.Second
+
+/*
+eventBus keeps a single backend subscription per kind of events, started on the first subscribe and kept for
+the program lifetime, and passes events on to all current subscribers. If starting it fails, the next subscribe
+tries again. A subscription ends when its context gets cancelled, e.g. when the widget that asked for it gets
+destroyed on a mainBox rebuild.
+*/
+type eventBus[T any] struct {
+	source  func(ctx context.Context) (<-chan T, error)
+	mu      sync.Mutex
+	started bool
+	subs    map[chan T]context.Context
+}
+
+func (b *eventBus[T]) subscribe(ctx context.Context) (<-chan T, error) {
+	b.mu.Lock()
+	if !b.started {
+		if err := b.start(); err != nil {
+			b.mu.Unlock()
+			return nil, err
+		}
+	}
+	ch := make(chan T, 1)
+	b.subs[ch] = ctx
+	b.mu.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	})
+	return ch, nil
+}
+
+// called with mu locked
+func (b *eventBus[T]) start() error {
+	events, err := b.source(context.Background())
+	if err != nil {
+		return err
+	}
+	b.subs = make(map[chan T]context.Context)
+	b.started = true
+
+	go func() {
+		for event := range events {
+			b.mu.Lock()
+			subs := make(map[chan T]context.Context, len(b.subs))
+			for ch, ctx := range b.subs {
+				subs[ch] = ctx
+			}
+			b.mu.Unlock()
+
+			// a subscriber gone in the meantime doesn't block the others
+			for ch, ctx := range subs {
+				select {
+				case ch <- event:
+				case <-ctx.Done():
+				}
+			}
+		}
+	}()
+	return nil
+}
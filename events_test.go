@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEventBusRetry(t *testing.T) {
+	attempts := 0
+	source := make(chan string, 1)
+	bus := &eventBus[string]{source: func(ctx context.Context) (<-chan string, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("no compositor yet")
+		}
+		return source, nil
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := bus.subscribe(ctx); err == nil {
+		t.Fatal("no error from the failed source")
+	}
+
+	// the next subscribe starts it again, and later ones share the subscription
+	events, err := bus.subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bus.subscribe(ctx); err != nil || attempts != 2 {
+		t.Fatalf("source started %v times, %v", attempts, err)
+	}
+
+	source <- "web"
+	select {
+	case event := <-events:
+		if event != "web" {
+			t.Errorf("event = %q", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gotk3/gotk3/gtk"
+)
+
+/*
+Grouping rules decide which task button a window belongs to. By default windows are grouped by their ID
+(app_id, or the window class of XWayland windows). The optional ~/.config/nwg-dock/groups.json file holds
+a list of rules, e.g.:
+
+	[
+		{"app_id": "^chrome-music\\.youtube\\.com", "group": "youtube-music", "name": "YouTube Music", "icon": "youtube-music"},
+		{"class": "^code-url-handler$", "group": "code"}
+	]
+
+Regular expressions given in "app_id", "class" and "title" must all match; as the dock knows a single identifier
+per window, "app_id" and "class" are matched against the same value. The first matching rule wins. The "group"
+key is what gets pinned, so it's best to use a .desktop file name there. "name" and "icon" (a name or a path)
+override what we find in the .desktop file.
+*/
+type groupRule struct {
+	AppID string `json:"app_id"`
+	Class string `json:"class"`
+	Title string `json:"title"`
+	Group string `json:"group"`
+	Name  string `json:"name"`
+	Icon  string `json:"icon"`
+
+	matchers []*regexp.Regexp
+	title    *regexp.Regexp
+}
+
+var groupRules []*groupRule
+
+func loadGroupRules(path string) ([]*groupRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*groupRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+
+	for i, r := range rules {
+		if r.Group == "" {
+			return nil, fmt.Errorf("rule %v: no group given", i+1)
+		}
+		for _, expr := range []string{r.AppID, r.Class} {
+			if expr == "" {
+				continue
+			}
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("rule %v: %s", i+1, err)
+			}
+			r.matchers = append(r.matchers, re)
+		}
+		if r.Title != "" {
+			if r.title, err = regexp.Compile(r.Title); err != nil {
+				return nil, fmt.Errorf("rule %v: %s", i+1, err)
+			}
+		}
+		if len(r.matchers) == 0 && r.title == nil {
+			return nil, fmt.Errorf("rule %v: nothing to match", i+1)
+		}
+	}
+	return rules, nil
+}
+
+func (r *groupRule) matches(t task) bool {
+	for _, re := range r.matchers {
+		if !re.MatchString(t.ID) {
+			return false
+		}
+	}
+	return r.title == nil || r.title.MatchString(t.Name)
+}
+
+/*
+returns the key of the group the task belongs to: the ID of the pin matching by "app_id" and "title", the group
+of the first matching rule, or the task ID
+*/
+func groupKey(t task) string {
+	if p := pinMatching(t); p != nil {
+		return p.ID
+	}
+	for _, r := range groupRules {
+		if r.matches(t) {
+			return r.Group
+		}
+	}
+	return t.ID
+}
+
+func sameGroup(key1, key2 string) bool {
+	return strings.EqualFold(strings.TrimSpace(key1), strings.TrimSpace(key2))
+}
+
+func groupRuleFor(key string) *groupRule {
+	for _, r := range groupRules {
+		if sameGroup(r.Group, key) {
+			return r
+		}
+	}
+	return nil
+}
+
+// display name of the group: the pin or rule override, or the name from the .desktop file
+func groupName(key string) string {
+	if p := pinFor(key); p != nil && (p.Label != "" || p.isCommand()) {
+		if p.Label != "" {
+			return p.Label
+		}
+		return p.Command
+	}
+	if r := groupRuleFor(key); r != nil && r.Name != "" {
+		return r.Name
+	}
+	return getName(key)
+}
+
+// icon of the group: the pin or rule override, or the icon from the .desktop file
+func groupImage(key string, size int) (*gtk.Image, error) {
+	icon := ""
+	if p := pinFor(key); p != nil && p.isCommand() {
+		pixbuf, err := p.pixbuf(size)
+		if err != nil {
+			return nil, err
+		}
+		return gtk.ImageNewFromPixbuf(pixbuf)
+	} else if p != nil && p.Icon != "" {
+		icon = p.icon()
+	} else if r := groupRuleFor(key); r != nil && r.Icon != "" {
+		icon = r.Icon
+	}
+	if icon != "" {
+		pixbuf, err := createPixbuf(icon, size)
+		if err == nil {
+			return gtk.ImageNewFromPixbuf(pixbuf)
+		}
+	}
+	return createImage(key, size)
+}
+
+// icon name for menus, with the pin or rule override
+func groupIconName(key string) (string, error) {
+	if p := pinFor(key); p != nil && p.Icon != "" {
+		return p.icon(), nil
+	} else if p != nil && p.isCommand() {
+		return commandPinIcon, nil
+	}
+	if r := groupRuleFor(key); r != nil && r.Icon != "" {
+		return r.Icon, nil
+	}
+	return getIcon(key)
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	hyprlandReconnectDelay    = 500 * time.Millisecond
+	hyprlandMaxReconnectDelay = 5 * time.Second
+)
+
+type hyprlandBackend struct {
+	requestSocket string
+	eventSocket   string
+	timeout       time.Duration
+	mu            sync.Mutex
+	urgent        map[int64]bool // clients don't tell urgency, so we follow "urgent" events
+}
+
+type hyprWorkspace struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Monitor string `json:"monitor"`
+}
+
+// special (scratchpad-like) workspaces have negative ids, but named ones have as well, so we go by the name
+func (ws hyprWorkspace) special() bool {
+	return strings.HasPrefix(ws.Name, "special")
+}
+
+type hyprClient struct {
+	Address      string        `json:"address"`
+	Mapped       bool          `json:"mapped"`
+	Workspace    hyprWorkspace `json:"workspace"`
+	Class        string        `json:"class"`
+	Title        string        `json:"title"`
+	InitialClass string        `json:"initialClass"`
+	PID          int64         `json:"pid"`
+	// 0 for the focused client
+	FocusHistoryID int `json:"focusHistoryID"`
+}
+
+type hyprMonitor struct {
+	Name            string        `json:"name"`
+	X               int           `json:"x"`
+	Y               int           `json:"y"`
+	Focused         bool          `json:"focused"`
+	ActiveWorkspace hyprWorkspace `json:"activeWorkspace"`
+}
+
+func newHyprlandBackend() *hyprlandBackend {
+	dir := hyprlandSocketDir()
+	return &hyprlandBackend{
+		requestSocket: filepath.Join(dir, ".socket.sock"),
+		eventSocket:   filepath.Join(dir, ".socket2.sock"),
+		timeout:       time.Second,
+		urgent:        make(map[int64]bool),
+	}
+}
+
+// Since v0.40 Hyprland keeps its sockets in $XDG_RUNTIME_DIR, before that in /tmp
+func hyprlandSocketDir() string {
+	signature := os.Getenv("HYPRLAND_INSTANCE_SIGNATURE")
+	if os.Getenv("XDG_RUNTIME_DIR") != "" {
+		dir := filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "hypr", signature)
+		if pathExists(dir) {
+			return dir
+		}
+	}
+	return filepath.Join("/tmp/hypr", signature)
+}
+
+// sends a request to the Hyprland request socket, returns the raw reply
+func (b *hyprlandBackend) request(cmd string) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", b.requestSocket, b.timeout)
+	if err != nil {
+		setConnected(false)
+		return nil, err
+	}
+	defer conn.Close()
+	setConnected(true)
+
+	if err := conn.SetDeadline(time.Now().Add(b.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(conn)
+}
+
+func (b *hyprlandBackend) requestJSON(cmd string, v interface{}) error {
+	reply, err := b.request(fmt.Sprintf("j/%s", cmd))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(reply, v)
+}
+
+func (b *hyprlandBackend) dispatch(args string) error {
+	reply, err := b.request(fmt.Sprintf("dispatch %s", args))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(reply)) != "ok" {
+		return fmt.Errorf("dispatch %q unsuccessful: %s", args, reply)
+	}
+	return nil
+}
+
+// list Hyprland clients, return tasks sorted by workspace numbers, and the focused workspace name
+func (b *hyprlandBackend) ListTasks() ([]task, string, error) {
+	var clients []hyprClient
+	if err := b.requestJSON("clients", &clients); err != nil {
+		return nil, "", err
+	}
+
+	var activeWorkspace hyprWorkspace
+	if err := b.requestJSON("activeworkspace", &activeWorkspace); err != nil {
+		return nil, "", err
+	}
+
+	workspaceOutputs, err := b.workspaceOutputs()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var tasks []task
+	for _, c := range clients {
+		// skip unmapped clients and the ones on special (scratchpad-like) workspaces
+		if !c.Mapped || c.Workspace.special() {
+			continue
+		}
+		t, err := b.createTask(c)
+		if err == nil {
+			t.Output = workspaceOutputs[c.Workspace.ID]
+			tasks = append(tasks, *t)
+		} else {
+			log.Warn(err)
+		}
+	}
+	sort.Slice(tasks, func(i int, j int) bool {
+		return tasks[i].WsNum < tasks[j].WsNum
+	})
+	return tasks, activeWorkspace.Name, nil
+}
+
+// clients only tell the monitor id, so we take monitor names from workspaces
+func (b *hyprlandBackend) workspaceOutputs() (map[int64]string, error) {
+	var workspaces []hyprWorkspace
+	if err := b.requestJSON("workspaces", &workspaces); err != nil {
+		return nil, err
+	}
+
+	result := make(map[int64]string)
+	for _, ws := range workspaces {
+		result[ws.ID] = ws.Monitor
+	}
+	return result, nil
+}
+
+func (b *hyprlandBackend) createTask(c hyprClient) (*task, error) {
+	t := &task{}
+	conID, err := hyprlandConID(c.Address)
+	if err != nil {
+		return nil, errors.New("damaged client data received, task skipped")
+	}
+	t.conID = conID
+	if c.Class != "" {
+		t.ID = c.Class
+	} else if c.InitialClass != "" {
+		t.ID = c.InitialClass
+	} else {
+		return nil, errors.New("damaged client data received, task skipped")
+	}
+	t.Name = c.Title
+	if c.PID > 0 {
+		t.PID = uint32(c.PID)
+	} else {
+		return nil, errors.New("damaged client data received, task skipped")
+	}
+
+	// named workspaces have negative ids; 0 is for workspaces w/o a number, as in Workspaces
+	t.WsNum = max(c.Workspace.ID, 0)
+	t.WsName = c.Workspace.Name
+	t.Focused = c.FocusHistoryID == 0
+
+	b.mu.Lock()
+	t.Urgent = b.urgent[conID]
+	b.mu.Unlock()
+
+	return t, nil
+}
+
+// Hyprland identifies windows by hex addresses, e.g. "0x55d7c1c0a7e0", we keep them as conID
+func hyprlandConID(address string) (int64, error) {
+	return strconv.ParseInt(strings.TrimPrefix(address, "0x"), 16, 64)
+}
+
+func hyprlandAddress(conID int64) string {
+	return fmt.Sprintf("address:0x%x", conID)
+}
+
+func (b *hyprlandBackend) Outputs() ([]output, error) {
+	var monitors []hyprMonitor
+	if err := b.requestJSON("monitors", &monitors); err != nil {
+		return nil, err
+	}
+
+	var result []output
+	for _, m := range monitors {
+		result = append(result, output{Name: m.Name, X: m.X, Y: m.Y})
+	}
+	return result, nil
+}
+
+/*
+Opens the event socket, and calls handler for each "EVENT>>DATA" line until the context gets cancelled.
+If the socket breaks (e.g. Hyprland restarted), it's reopened, and resync called, as events may have been missed.
+*/
+func (b *hyprlandBackend) subscribe(ctx context.Context, handler func(event, data string), resync func()) error {
+	conn, err := net.DialTimeout("unix", b.eventSocket, b.timeout)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		delay := hyprlandReconnectDelay
+		for {
+			stop := context.AfterFunc(ctx, func() {
+				_ = conn.Close()
+			})
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				event, data, found := strings.Cut(scanner.Text(), ">>")
+				if found {
+					handler(event, data)
+				}
+			}
+			stop()
+			_ = conn.Close()
+			if ctx.Err() != nil {
+				return
+			}
+			setConnected(false)
+			log.Warnf("Hyprland events lost: %v, reconnecting", scanner.Err())
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				delay = min(delay*2, hyprlandMaxReconnectDelay)
+				if conn, err = net.DialTimeout("unix", b.eventSocket, b.timeout); err == nil {
+					break
+				}
+			}
+			delay = hyprlandReconnectDelay
+			setConnected(true)
+			go resync()
+		}
+	}()
+
+	return nil
+}
+
+func (b *hyprlandBackend) TaskEvents(ctx context.Context) (<-chan TaskChange, error) {
+	taskUpdateChannel := make(chan TaskChange, 1)
+
+	err := b.subscribe(ctx, func(event, data string) {
+		var change string
+		switch event {
+		case "openwindow":
+			change = "new"
+		case "closewindow":
+			change = "close"
+		case "movewindow":
+			change = "move"
+		case "activewindowv2":
+			change = "focus"
+		case "windowtitle":
+			change = "title"
+		case "urgent":
+			change = "urgent"
+		case "changefloatingmode":
+			change = "floating"
+		case "moveworkspace", "renameworkspace":
+			// tasks of the workspace have moved to another monitor as well, or carry the old name; let's re-list them
+			taskUpdateChannel <- TaskChange{Change: "move"}
+			return
+		default:
+			return
+		}
+		// the window address (w/o the "0x" prefix) always comes first
+		address, _, _ := strings.Cut(data, ",")
+		if address == "" {
+			return
+		}
+		// urgency lasts until the window gets focused or closed
+		if conID, err := hyprlandConID(address); err == nil {
+			b.mu.Lock()
+			if change == "urgent" {
+				b.urgent[conID] = true
+			} else if change == "focus" || change == "close" {
+				delete(b.urgent, conID)
+			}
+			b.mu.Unlock()
+		}
+		taskUpdateChannel <- b.taskChange(change, address)
+	}, func() {
+		taskUpdateChannel <- TaskChange{Change: "reload"}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return taskUpdateChannel, nil
+}
+
+// fills the TaskChange with the client state; on failure Task stays nil, and the task list gets re-listed
+func (b *hyprlandBackend) taskChange(change, address string) TaskChange {
+	taskChange := TaskChange{Change: change}
+	conID, err := hyprlandConID(address)
+	if err != nil {
+		return taskChange
+	}
+	if change == "close" {
+		taskChange.Task = &task{conID: conID}
+		return taskChange
+	}
+
+	var clients []hyprClient
+	if err := b.requestJSON("clients", &clients); err != nil {
+		log.Warnf("Unable to get clients: %s", err)
+		return taskChange
+	}
+	for _, c := range clients {
+		if id, _ := hyprlandConID(c.Address); id != conID {
+			continue
+		}
+		if !c.Mapped || c.Workspace.special() {
+			// e.g. moved to a special workspace, so no longer a task
+			taskChange.Change = "close"
+			taskChange.Task = &task{conID: conID}
+		} else if workspaceOutputs, err := b.workspaceOutputs(); err == nil {
+			taskChange.Task, _ = b.createTask(c)
+			if taskChange.Task != nil {
+				taskChange.Task.Output = workspaceOutputs[c.Workspace.ID]
+			}
+		}
+		break
+	}
+	return taskChange
+}
+
+func (b *hyprlandBackend) WorkspaceEvents(ctx context.Context) (<-chan string, error) {
+	workspaceUpdateChannel := make(chan string, 1)
+
+	err := b.subscribe(ctx, func(event, data string) {
+		// "workspace" carries the name only, and "focusedmon" the monitor and the workspace name;
+		// "renameworkspace" may have renamed the focused one
+		if event == "workspace" || event == "focusedmon" || event == "renameworkspace" {
+			var activeWorkspace hyprWorkspace
+			if err := b.requestJSON("activeworkspace", &activeWorkspace); err != nil {
+				log.Warnf("Unable to get active workspace: %s", err)
+				return
+			}
+			workspaceUpdateChannel <- activeWorkspace.Name
+		}
+	}, func() {
+		var activeWorkspace hyprWorkspace
+		if err := b.requestJSON("activeworkspace", &activeWorkspace); err == nil {
+			workspaceUpdateChannel <- activeWorkspace.Name
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return workspaceUpdateChannel, nil
+}
+
+func (b *hyprlandBackend) FocusCon(conID int64) error {
+	return b.dispatch(fmt.Sprintf("focuswindow %s", hyprlandAddress(conID)))
+}
+
+func (b *hyprlandBackend) KillCon(conID int64) error {
+	return b.dispatch(fmt.Sprintf("closewindow %s", hyprlandAddress(conID)))
+}
+
+func (b *hyprlandBackend) MoveConToWorkspace(conID int64, wsNum int) error {
+	return b.dispatch(fmt.Sprintf("movetoworkspacesilent %v,%s", wsNum, hyprlandAddress(conID)))
+}
+
+func (b *hyprlandBackend) FocusWorkspace(name string) error {
+	if _, err := strconv.Atoi(name); err == nil {
+		return b.dispatch(fmt.Sprintf("workspace %s", name))
+	}
+	return b.dispatch(fmt.Sprintf("workspace name:%s", name))
+}
+
+func (b *hyprlandBackend) Workspaces() ([]workspace, error) {
+	var hyprWorkspaces []hyprWorkspace
+	if err := b.requestJSON("workspaces", &hyprWorkspaces); err != nil {
+		return nil, err
+	}
+	var monitors []hyprMonitor
+	if err := b.requestJSON("monitors", &monitors); err != nil {
+		return nil, err
+	}
+
+	var workspaces []workspace
+	for _, ws := range hyprWorkspaces {
+		if ws.special() {
+			continue
+		}
+		w := workspace{Name: ws.Name, Output: ws.Monitor}
+		// named workspaces get (negative) ids as well, but their names are not numbers then
+		if num, err := strconv.ParseInt(ws.Name, 10, 64); err == nil {
+			w.Num = num
+		}
+		for _, m := range monitors {
+			if m.ActiveWorkspace.ID == ws.ID {
+				w.Visible = true
+				w.Focused = m.Focused
+			}
+		}
+		workspaces = append(workspaces, w)
+	}
+	sortWorkspaces(workspaces)
+	return workspaces, nil
+}
+
+func (b *hyprlandBackend) Supports(f feature) bool {
+	return f == featureWorkspaces
+}
+
+func (b *hyprlandBackend) SetMinimized(conID int64, minimized bool) error {
+	return errNotSupported
+}
+
+func (b *hyprlandBackend) SetMaximized(conID int64, maximized bool) error {
+	return errNotSupported
+}
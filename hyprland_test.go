@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a fake Hyprland, serving canned replies on the request socket, and events written by the test on the event one
+type fakeHyprland struct {
+	mu         sync.Mutex
+	replies    map[string]string
+	requests   []string
+	eventConns chan net.Conn
+}
+
+func newFakeHyprland(t *testing.T) (*fakeHyprland, *hyprlandBackend) {
+	dir := t.TempDir()
+	b := &hyprlandBackend{
+		requestSocket: filepath.Join(dir, ".socket.sock"),
+		eventSocket:   filepath.Join(dir, ".socket2.sock"),
+		timeout:       time.Second,
+		urgent:        make(map[int64]bool),
+	}
+	f := &fakeHyprland{replies: make(map[string]string), eventConns: make(chan net.Conn, 1)}
+
+	requests, err := net.Listen("unix", b.requestSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := net.Listen("unix", b.eventSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		requests.Close()
+		events.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := requests.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 4096)
+			n, _ := conn.Read(buf)
+			_, _ = conn.Write([]byte(f.reply(string(buf[:n]))))
+			conn.Close()
+		}
+	}()
+	go func() {
+		for {
+			conn, err := events.Accept()
+			if err != nil {
+				return
+			}
+			f.eventConns <- conn
+		}
+	}()
+	return f, b
+}
+
+func (f *fakeHyprland) reply(request string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, request)
+	if reply, ok := f.replies[request]; ok {
+		return reply
+	}
+	if strings.HasPrefix(request, "dispatch ") {
+		return "ok"
+	}
+	return "unknown request"
+}
+
+func (f *fakeHyprland) set(request, reply string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.replies[request] = reply
+}
+
+func (f *fakeHyprland) lastRequest() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.requests) == 0 {
+		return ""
+	}
+	return f.requests[len(f.requests)-1]
+}
+
+func (f *fakeHyprland) eventConn(t *testing.T) net.Conn {
+	select {
+	case conn := <-f.eventConns:
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event socket connection")
+		return nil
+	}
+}
+
+func receiveChange(t *testing.T, ch <-chan TaskChange) TaskChange {
+	select {
+	case change := <-ch:
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatal("no task change")
+		return TaskChange{}
+	}
+}
+
+const (
+	hyprClients = `[
+		{"address": "0xa1", "mapped": true, "workspace": {"id": 2, "name": "2"}, "class": "foot",
+			"title": "htop", "initialClass": "foot", "pid": 101, "focusHistoryID": 1},
+		{"address": "0xb2", "mapped": true, "workspace": {"id": -1338, "name": "web"}, "class": "",
+			"title": "Mozilla Firefox", "initialClass": "firefox", "pid": 102, "focusHistoryID": 0},
+		{"address": "0xc3", "mapped": true, "workspace": {"id": -98, "name": "special:magic"}, "class": "foot",
+			"title": "scratch", "initialClass": "foot", "pid": 103, "focusHistoryID": 2},
+		{"address": "0xd4", "mapped": false, "workspace": {"id": 2, "name": "2"}, "class": "foot",
+			"title": "", "initialClass": "foot", "pid": 104, "focusHistoryID": 3}
+	]`
+	hyprWorkspaces = `[
+		{"id": 2, "name": "2", "monitor": "DP-1"},
+		{"id": -1338, "name": "web", "monitor": "HDMI-A-1"},
+		{"id": -98, "name": "special:magic", "monitor": "DP-1"}
+	]`
+	hyprMonitors = `[
+		{"name": "DP-1", "x": 0, "y": 0, "focused": false, "activeWorkspace": {"id": 2, "name": "2"}},
+		{"name": "HDMI-A-1", "x": 1920, "y": 0, "focused": true, "activeWorkspace": {"id": -1338, "name": "web"}}
+	]`
+)
+
+func setHyprState(f *fakeHyprland) {
+	f.set("j/clients", hyprClients)
+	f.set("j/workspaces", hyprWorkspaces)
+	f.set("j/monitors", hyprMonitors)
+	f.set("j/activeworkspace", `{"id": -1338, "name": "web", "monitor": "HDMI-A-1"}`)
+}
+
+func TestHyprlandListTasks(t *testing.T) {
+	f, b := newFakeHyprland(t)
+	setHyprState(f)
+
+	tasks, wsName, err := b.ListTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wsName != "web" {
+		t.Errorf("focused workspace = %q, want \"web\"", wsName)
+	}
+	// unmapped clients and the ones on special workspaces are skipped; named workspaces come first
+	want := []task{
+		{conID: 0xb2, ID: "firefox", Name: "Mozilla Firefox", PID: 102, WsNum: 0, WsName: "web", Focused: true,
+			Output: "HDMI-A-1"},
+		{conID: 0xa1, ID: "foot", Name: "htop", PID: 101, WsNum: 2, WsName: "2", Output: "DP-1"},
+	}
+	if len(tasks) != len(want) {
+		t.Fatalf("tasks = %+v, want %+v", tasks, want)
+	}
+	for i := range want {
+		if tasks[i] != want[i] {
+			t.Errorf("task %v = %+v, want %+v", i, tasks[i], want[i])
+		}
+	}
+}
+
+func TestHyprlandOutputs(t *testing.T) {
+	f, b := newFakeHyprland(t)
+	setHyprState(f)
+
+	outputs, err := b.Outputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []output{{Name: "DP-1", X: 0, Y: 0}, {Name: "HDMI-A-1", X: 1920, Y: 0}}
+	if len(outputs) != len(want) || outputs[0] != want[0] || outputs[1] != want[1] {
+		t.Errorf("outputs = %+v, want %+v", outputs, want)
+	}
+}
+
+func TestHyprlandWorkspaces(t *testing.T) {
+	f, b := newFakeHyprland(t)
+	setHyprState(f)
+
+	workspaces, err := b.Workspaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []workspace{
+		{Num: 2, Name: "2", Output: "DP-1", Visible: true},
+		{Num: 0, Name: "web", Output: "HDMI-A-1", Visible: true, Focused: true},
+	}
+	if len(workspaces) != len(want) {
+		t.Fatalf("workspaces = %+v, want %+v", workspaces, want)
+	}
+	for i := range want {
+		if workspaces[i] != want[i] {
+			t.Errorf("workspace %v = %+v, want %+v", i, workspaces[i], want[i])
+		}
+	}
+}
+
+func TestHyprlandTaskChange(t *testing.T) {
+	f, b := newFakeHyprland(t)
+	setHyprState(f)
+
+	change := b.taskChange("title", "a1")
+	if change.Change != "title" || change.Task == nil || change.Task.Name != "htop" || change.Task.Output != "DP-1" {
+		t.Errorf("title change = %+v, task %+v", change, change.Task)
+	}
+
+	// a window moved to a special workspace is no longer a task
+	change = b.taskChange("move", "c3")
+	if change.Change != "close" || change.Task == nil || change.Task.conID != 0xc3 {
+		t.Errorf("move to a special workspace = %+v, task %+v", change, change.Task)
+	}
+
+	// a window on a named workspace stays, despite the negative workspace id
+	change = b.taskChange("move", "b2")
+	if change.Change != "move" || change.Task == nil || change.Task.WsName != "web" {
+		t.Errorf("move to a named workspace = %+v, task %+v", change, change.Task)
+	}
+
+	// unknown windows leave Task nil, for a resync
+	change = b.taskChange("title", "ff")
+	if change.Task != nil {
+		t.Errorf("unknown window change = %+v, task %+v", change, change.Task)
+	}
+
+	// closed windows are gone already, so they're not looked up
+	f.set("j/clients", "[]")
+	change = b.taskChange("close", "a1")
+	if change.Change != "close" || change.Task == nil || change.Task.conID != 0xa1 {
+		t.Errorf("close = %+v, task %+v", change, change.Task)
+	}
+}
+
+func TestHyprlandDispatch(t *testing.T) {
+	f, b := newFakeHyprland(t)
+
+	for _, tc := range []struct {
+		call func() error
+		want string
+	}{
+		{func() error { return b.FocusCon(0xa1) }, "dispatch focuswindow address:0xa1"},
+		{func() error { return b.KillCon(0xa1) }, "dispatch closewindow address:0xa1"},
+		{func() error { return b.MoveConToWorkspace(0xa1, 3) }, "dispatch movetoworkspacesilent 3,address:0xa1"},
+		{func() error { return b.FocusWorkspace("3") }, "dispatch workspace 3"},
+		{func() error { return b.FocusWorkspace("web") }, "dispatch workspace name:web"},
+	} {
+		if err := tc.call(); err != nil {
+			t.Errorf("%s: %s", tc.want, err)
+		}
+		if got := f.lastRequest(); got != tc.want {
+			t.Errorf("request = %q, want %q", got, tc.want)
+		}
+	}
+
+	f.set("dispatch focuswindow address:0xa1", "No such window")
+	if err := b.FocusCon(0xa1); err == nil {
+		t.Error("unsuccessful dispatch gave no error")
+	}
+}
+
+func TestHyprlandEventsAndResync(t *testing.T) {
+	f, b := newFakeHyprland(t)
+	setHyprState(f)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := b.TaskEvents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := f.eventConn(t)
+
+	for _, tc := range []struct{ event, want string }{
+		{"openwindow>>a1,2,foot,htop\n", "new"},
+		{"activewindowv2>>a1\n", "focus"},
+		{"movewindow>>a1,3\n", "move"},
+		{"windowtitle>>a1\n", "title"},
+		{"urgent>>a1\n", "urgent"},
+		{"closewindow>>a1\n", "close"},
+	} {
+		_, _ = conn.Write([]byte(tc.event))
+		change := receiveChange(t, changes)
+		if change.Change != tc.want || change.Task == nil || change.Task.conID != 0xa1 {
+			t.Errorf("%q: change = %+v, want %q", tc.event, change, tc.want)
+		}
+	}
+
+	// a workspace moved to another monitor takes its tasks along, so they get re-listed
+	_, _ = conn.Write([]byte("moveworkspace>>2,HDMI-A-1\n"))
+	change := receiveChange(t, changes)
+	if change.Change != "move" || change.Task != nil {
+		t.Errorf("moveworkspace = %+v, task %+v", change, change.Task)
+	}
+
+	// urgency lasts until the window gets focused
+	_, _ = conn.Write([]byte("urgent>>a1\n"))
+	change = receiveChange(t, changes)
+	if change.Change != "urgent" || change.Task == nil || !change.Task.Urgent {
+		t.Errorf("urgent = %+v, task %+v", change, change.Task)
+	}
+	_, _ = conn.Write([]byte("activewindowv2>>a1\n"))
+	change = receiveChange(t, changes)
+	if change.Change != "focus" || change.Task == nil || change.Task.Urgent {
+		t.Errorf("focus = %+v, task %+v", change, change.Task)
+	}
+
+	// Hyprland restarts: events may have been missed, so a resync gets requested after reconnecting
+	conn.Close()
+	conn = f.eventConn(t)
+	change = receiveChange(t, changes)
+	if change.Change != "reload" || change.Task != nil {
+		t.Errorf("after reconnecting = %+v, task %+v", change, change.Task)
+	}
+
+	_, _ = conn.Write([]byte("windowtitle>>b2\n"))
+	change = receiveChange(t, changes)
+	if change.Change != "title" || change.Task == nil || change.Task.ID != "firefox" {
+		t.Errorf("title after reconnecting = %+v, task %+v", change, change.Task)
+	}
+}
+
+func TestHyprlandWorkspaceRename(t *testing.T) {
+	f, b := newFakeHyprland(t)
+	setHyprState(f)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := b.TaskEvents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskConn := f.eventConn(t)
+	workspaces, err := b.WorkspaceEvents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workspaceConn := f.eventConn(t)
+
+	// tasks of the renamed workspace carry the old name, so they get re-listed
+	f.set("j/activeworkspace", `{"id": -1338, "name": "www", "monitor": "HDMI-A-1"}`)
+	_, _ = taskConn.Write([]byte("renameworkspace>>-1338,www\n"))
+	change := receiveChange(t, changes)
+	if change.Change != "move" || change.Task != nil {
+		t.Errorf("renameworkspace = %+v, task %+v", change, change.Task)
+	}
+
+	// and the focused one may have got the new name
+	_, _ = workspaceConn.Write([]byte("renameworkspace>>-1338,www\n"))
+	select {
+	case name := <-workspaces:
+		if name != "www" {
+			t.Errorf("focused workspace = %q, want \"www\"", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no workspace change")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
@@ -35,17 +36,22 @@ var (
 	dataHome                           string
 	configDirectory                    string
 	pinnedFile                         string
-	pinned                             []string
+	pinned                             []*pin
 	oldTasks                           []task
 	mainBox                            *gtk.Box
+	wsStripBox                         *gtk.Box
 	src                                glib.SourceHandle
 	refreshMainBoxChannel              chan struct{} = make(chan struct{}, 1)
 	outerOrientation, innerOrientation gtk.Orientation
 	widgetAnchor, menuAnchor           gdk.Gravity
 	imgSizeScaled                      int
-	currentWsNum, targetWsNum          int64
 	win                                *gtk.Window
-	windowStateChannel                 chan WindowState = make(chan WindowState, 1)
+	windowStateChannel                 chan WindowState    = make(chan WindowState, 1)
+	taskList                           *taskModel          = &taskModel{}
+	connectionStateChannel             chan bool           = make(chan bool, 1)
+	profileChannel                     chan string         = make(chan string, 1)
+	taskEvents                         *eventBus[taskDiff] = &eventBus[taskDiff]{source: getTaskChangesChannel}
+	workspaceEvents                    *eventBus[string]   = &eventBus[string]{source: getWorkspaceChangesChannel}
 	detectorEnteredAt                  int64
 	appIdsToIgnore                     []string
 )
@@ -57,7 +63,7 @@ var displayVersion = flag.Bool("v", false, "display Version information")
 var autohide = flag.Bool("d", false, "auto-hiDe: show dock when hotspot hovered, close when left or a button clicked")
 var full = flag.Bool("f", false, "take Full screen width/height")
 var ignoreAppIds = flag.String("g", "", "quote-delimited, space-separated app_id list to iGnore in the dock")
-var numWS = flag.Int64("w", 8, "number of Workspaces you use")
+var numWS = flag.Int64("w", 0, "number of Workspaces you use; if given, the ones that don't exist yet are offered as well")
 var position = flag.String("p", "bottom", "Position: \"bottom\", \"top\" or \"left\"")
 var exclusive = flag.Bool("x", false, "set eXclusive zone: move other windows aside; overrides the \"-l\" argument")
 var imgSize = flag.Int("i", 48, "Icon size")
@@ -71,9 +77,96 @@ var marginRight = flag.Int("mr", 0, "Margin Right")
 var marginBottom = flag.Int("mb", 0, "Margin Bottom")
 var hotspotDelay = flag.Int64("hd", 20, "Hotspot Delay [ms]; the smaller, the faster mouse pointer needs to enter hotspot for the dock to appear; set 0 to disable")
 var noWs = flag.Bool("nows", false, "don't show the workspace switcher")
+var wsScroll = flag.String("wsc", "all", "Workspace sCroll: cycle \"all\" workspaces, the ones on the dock's \"output\", or on the \"focused\" output")
+var wsSkipEmpty = flag.Bool("wse", false, "Workspace scroll: skip Empty workspaces")
+var wsStrip = flag.Bool("wss", false, "Workspace Strip: show a button per workspace instead of the switcher")
 var noLauncher = flag.Bool("nolauncher", false, "don't show the launcher button")
 var resident = flag.Bool("r", false, "Leave the program resident, but w/o hotspot")
 var debug = flag.Bool("debug", false, "turn on debug messages")
+var outputTasks = flag.Bool("ot", false, "Output Tasks: show only tasks from the output given with \"-o\"; one dock per output may run then")
+var currentWsTasks = flag.Bool("cw", false, "Current Workspace: show only unpinned tasks from the current workspace")
+var attention = flag.String("at", "pulse", "ATtention animation of urgent task buttons: \"pulse\", \"bounce\" or \"none\"")
+var refreshDelay = flag.Int("rd", 30, "Refresh Delay [ms]: window events coming within it are coalesced into a single refresh")
+var noThumbnails = flag.Bool("nothumbs", false, "don't show window thumbnails in the instance popup, just titles")
+var recordFile = flag.String("record", "", "record task lists and compositor events to a JSON lines file, e.g. for a bug report")
+var replayFile = flag.String("replay", "", "replay a file written with \"-record\" instead of talking to the compositor")
+var pinProfile = flag.String("pp", "", "Pin Profile to use; switches the running instance to it, if any")
+var backendName = flag.String("b", "", "compositor Backend: \"sway\", \"hyprland\" or \"wlr\" (foreign toplevel management); auto-detected if not given")
+
+const defaultStyle = `
+@keyframes attention-pulse {
+	from { background-color: rgba (255, 160, 0, 0.1); }
+	to { background-color: rgba (255, 160, 0, 0.6); }
+}
+
+@keyframes attention-bounce {
+	0% { padding-top: 4px; padding-bottom: 4px; }
+	50% { padding-top: 0px; padding-bottom: 8px; }
+	100% { padding-top: 4px; padding-bottom: 4px; }
+}
+
+button.urgent {
+	background-color: rgba (255, 160, 0, 0.35);
+	border-radius: 2px;
+}
+
+button.attention-pulse {
+	animation: attention-pulse 0.8s ease-in-out infinite alternate;
+}
+
+button.attention-bounce {
+	animation: attention-bounce 0.6s ease-in-out infinite;
+}
+
+button.active {
+	background-color: rgba (255, 255, 255, 0.1);
+	border-radius: 2px;
+}
+
+menuitem.focused label {
+	font-weight: bold;
+}
+
+button.minimized image, menuitem.minimized label {
+	opacity: 0.5;
+}
+
+#box.disconnected {
+	opacity: 0.5;
+}
+
+.workspace-indicator {
+	background-color: rgba (255, 255, 255, 0.15);
+	border-radius: 6px;
+	color: #fff;
+	font-size: 16px;
+	font-weight: bold;
+}
+
+button.workspace.visible .workspace-indicator {
+	background-color: rgba (255, 255, 255, 0.3);
+}
+
+button.workspace.focused .workspace-indicator {
+	background-color: rgba (255, 255, 255, 0.5);
+}
+
+button.workspace.urgent .workspace-indicator {
+	background-color: rgba (255, 160, 0, 0.6);
+}
+
+button.workspace.empty .workspace-indicator {
+	opacity: 0.4;
+}
+
+.window-count {
+	background-color: rgba (0, 0, 0, 0.7);
+	border-radius: 8px;
+	color: #fff;
+	font-size: 10px;
+	padding: 0 4px;
+}
+`
 
 func buildMainBox(tasks []task, vbox *gtk.Box) {
 	mainBox.Destroy()
@@ -87,21 +180,17 @@ func buildMainBox(tasks []task, vbox *gtk.Box) {
 		vbox.PackStart(mainBox, true, false, 0)
 	}
 
-	var err error
-	pinned, err = loadTextFile(pinnedFile)
-	if err != nil {
-		pinned = nil
-	}
+	tasks = visibleTasks(tasks)
 
 	var allItems []string
 	for _, cntPin := range pinned {
-		if !isIn(allItems, cntPin) {
-			allItems = append(allItems, cntPin)
+		if !isIn(allItems, cntPin.ID) {
+			allItems = append(allItems, cntPin.ID)
 		}
 	}
 	for _, cntTask := range tasks {
-		if !isIn(allItems, cntTask.ID) && !strings.Contains(*launcherCmd, cntTask.ID) {
-			allItems = append(allItems, cntTask.ID)
+		if key := groupKey(cntTask); !isIn(allItems, key) && !strings.Contains(*launcherCmd, cntTask.ID) {
+			allItems = append(allItems, key)
 		}
 	}
 
@@ -125,8 +214,10 @@ func buildMainBox(tasks []task, vbox *gtk.Box) {
 		}
 	}
 
+	taskButtons = make(map[string]*taskButtonEntry)
 	var alreadyAdded []string
-	for _, pin := range pinned {
+	for _, p := range pinned {
+		pin := p.ID
 		if !inTasks(tasks, pin) {
 			if !isIn(appIdsToIgnore, pin) {
 				button := pinnedButton(pin)
@@ -141,11 +232,13 @@ func buildMainBox(tasks []task, vbox *gtk.Box) {
 				if len(instances) == 1 {
 					button := taskButton(task, instances)
 					mainBox.PackStart(button, false, false, 0)
-				} else if !isIn(alreadyAdded, task.ID) {
+					taskButtons[pin] = &taskButtonEntry{box: button, instances: instances}
+				} else if !isIn(alreadyAdded, pin) {
 					button := taskButton(task, instances)
 					mainBox.PackStart(button, false, false, 0)
-					alreadyAdded = append(alreadyAdded, task.ID)
-					taskMenu(task.ID, instances)
+					taskButtons[pin] = &taskButtonEntry{box: button, instances: instances}
+					alreadyAdded = append(alreadyAdded, pin)
+					taskMenu(pin, instances)
 				} else {
 					continue
 				}
@@ -155,93 +248,27 @@ func buildMainBox(tasks []task, vbox *gtk.Box) {
 		}
 	}
 
-	alreadyAdded = nil
-	for _, task := range tasks {
-		if !inPinned(task.ID) {
-			instances := taskInstances(task.ID, tasks)
-			if !isIn(appIdsToIgnore, task.ID) {
-				if len(instances) == 1 {
-					button := taskButton(task, instances)
-					mainBox.PackStart(button, false, false, 0)
-				} else if !isIn(alreadyAdded, task.ID) {
-					button := taskButton(task, instances)
-					mainBox.PackStart(button, false, false, 0)
-					alreadyAdded = append(alreadyAdded, task.ID)
-					taskMenu(task.ID, instances)
-				} else {
-					continue
-				}
+	for _, key := range unpinnedKeys(tasks) {
+		instances := taskInstances(key, tasks)
+		task := instances[0]
+		if !isIn(appIdsToIgnore, task.ID) {
+			button := taskButton(task, instances)
+			mainBox.PackStart(button, false, false, 0)
+			taskButtons[key] = &taskButtonEntry{box: button, instances: instances}
+			if len(instances) > 1 {
+				taskMenu(key, instances)
 			}
 		}
 	}
 
-	if !*noWs {
-		wsButton, e := gtk.ButtonNew()
-		if e != nil {
-			return
-		}
-		wsPixbuf, e := gdk.PixbufNewFromFileAtSize(filepath.Join(dataHome, fmt.Sprintf("nwg-dock/images/%v.svg", currentWsNum)),
-			imgSizeScaled, imgSizeScaled)
-		if e == nil {
-			wsImage, _ := gtk.ImageNewFromPixbuf(wsPixbuf)
-			wsButton.SetImage(wsImage)
-			wsButton.SetAlwaysShowImage(true)
-			wsButton.AddEvents(int(gdk.SCROLL_MASK))
-
-			wsUpdateChannel := getWorkspaceChangesChannel(context.Background())
-			go func() {
-				for {
-					activeWorkspace := <-wsUpdateChannel
-					targetWsNum = activeWorkspace
-
-					glib.TimeoutAdd(0, func() bool {
-						wsPixbuf, e = gdk.PixbufNewFromFileAtSize(filepath.Join(dataHome, fmt.Sprintf("nwg-dock/images/%v.svg", activeWorkspace)),
-							imgSizeScaled, imgSizeScaled)
-
-						if e == nil {
-							wsImage, _ = gtk.ImageNewFromPixbuf(wsPixbuf)
-							wsButton.SetImage(wsImage)
-						} else {
-							log.Warnf("Unable set set workspace image: %v", activeWorkspace)
-						}
-
-						return false
-					})
-				}
-			}()
-
-			wsButton.Connect("clicked", func() {
-				focusWorkspace(targetWsNum)
-			})
-
-			wsButton.Connect("enter-notify-event", cancelClose)
-
-			wsButton.Connect("scroll-event", func(btn *gtk.Button, e *gdk.Event) bool {
-				event := gdk.EventScrollNewFromEvent(e)
-				if event.Direction() == gdk.SCROLL_UP {
-					if targetWsNum < *numWS && targetWsNum < 20 {
-						targetWsNum++
-					} else {
-						targetWsNum = 1
-					}
-
-					wsUpdateChannel <- targetWsNum
-					return true
-				} else if event.Direction() == gdk.SCROLL_DOWN {
-					if targetWsNum > 1 {
-						targetWsNum--
-					} else {
-						targetWsNum = *numWS
-					}
-
-					wsUpdateChannel <- targetWsNum
-					return true
-				}
-				return false
-			})
-		}
-		if wsButton != nil {
-			mainBox.PackStart(wsButton, false, false, 0)
+	wsStripBox = nil
+	if !*noWs && backend.Supports(featureWorkspaces) {
+		if *wsStrip {
+			wsStripBox, _ = gtk.BoxNew(innerOrientation, 0)
+			mainBox.PackStart(wsStripBox, false, false, 0)
+			refreshWorkspaceStrip()
+		} else {
+			mainBox.PackStart(workspaceButton(), false, false, 0)
 		}
 	}
 
@@ -360,15 +387,35 @@ func main() {
 	if *resident {
 		log.Info("Starting in resident mode")
 	}
+	if *outputTasks && *targetOutput == "" {
+		log.Warn("Output Tasks argument requires the Output to be given, ignoring -ot!")
+		*outputTasks = false
+	}
+	if *wsScroll != "all" && *wsScroll != "output" && *wsScroll != "focused" {
+		log.Fatalf("Unknown Workspace sCroll value: '%s'", *wsScroll)
+	}
+	if *attention != "pulse" && *attention != "bounce" && *attention != "none" {
+		log.Fatalf("Unknown ATtention animation: '%s'", *attention)
+	}
+	if *pinProfile != "" && !validProfile(*pinProfile) {
+		log.Fatalf("Invalid pin profile name: '%s'", *pinProfile)
+	}
 	if *ignoreAppIds != "" {
 		log.Infof("Ignoring app_ids: '%s'", *ignoreAppIds)
 		appIdsToIgnore = strings.Split(*ignoreAppIds, " ")
 	}
 
+	// Use md5-hashed $USER name to create unique lock files for multiple users
+	lockFilePath := fmt.Sprintf("%s/nwg-dock-%s.lock", tempDir(), md5Hash(os.Getenv("USER")))
+	// In the output tasks mode there may be a dock per output, so each of them needs a lock file of its own
+	if *outputTasks {
+		lockFilePath = fmt.Sprintf("%s/nwg-dock-%s-%s.lock", tempDir(), md5Hash(os.Getenv("USER")), *targetOutput)
+	}
+
 	// Gentle SIGTERM handler thanks to reiki4040 https://gist.github.com/reiki4040/be3705f307d3cd136e85
 	// v0.2: we also need to support SIGUSR from now on.
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGUSR1)
+	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
 
 	go func() {
 		for {
@@ -392,6 +439,14 @@ func main() {
 					log.Info("SIGUSR1 received, and I'm not resident, bye bye!")
 					gtk.MainQuit()
 				}
+			case syscall.SIGUSR2:
+				name, err := readTextFile(profileRequestFile(lockFilePath))
+				if err != nil {
+					log.Warnf("SIGUSR2 received, but no pin profile requested: %s", err)
+					continue
+				}
+				log.Debugf("SIGUSR2 received, switching to the '%s' pin profile", name)
+				profileChannel <- strings.TrimSpace(name)
 			default:
 				log.Warn("Unknown signal")
 			}
@@ -402,15 +457,19 @@ func main() {
 	// Since v0.2 we can't just send SIGKILL if running instance found. We'll send SIGUSR1 instead.
 	// If it's running with `-r` or `-d` flag, it'll show the window. If not - it will die.
 
-	// Use md5-hashed $USER name to create unique lock files for multiple users
-	lockFilePath := fmt.Sprintf("%s/nwg-dock-%s.lock", tempDir(), md5Hash(os.Getenv("USER")))
 	lockFile, err := singleinstance.CreateLockFile(lockFilePath)
 	if err != nil {
 		pid, err := readTextFile(lockFilePath)
 		if err == nil {
 			i, err := strconv.Atoi(pid)
 			if err == nil {
-				if *autohide || *resident {
+				if *pinProfile != "" {
+					if err := os.WriteFile(profileRequestFile(lockFilePath), []byte(*pinProfile), 0600); err != nil {
+						log.Fatalf("Unable to request the pin profile: %s", err)
+					}
+					_ = syscall.Kill(i, syscall.SIGUSR2)
+					log.Infof("Asking the running instance to use the '%s' pin profile and bye, bye!", *pinProfile)
+				} else if *autohide || *resident {
 					log.Info("Running instance found, terminating...")
 				} else {
 					_ = syscall.Kill(i, syscall.SIGUSR1)
@@ -448,17 +507,73 @@ func main() {
 		}
 	}
 
-	cacheDirectory := cacheDir()
-	if cacheDirectory == "" {
-		log.Panic("Couldn't determine cache directory location")
+	// pinned items of older versions are only there to migrate
+	if cacheDirectory := cacheDir(); cacheDirectory != "" {
+		migratePins(filepath.Join(cacheDirectory, "nwg-dock-pinned"), profileFile(defaultProfile))
 	}
-	pinnedFile = filepath.Join(cacheDirectory, "nwg-dock-pinned")
 	cssFile := filepath.Join(configDirectory, *cssFileName)
 
 	appDirs = getAppDirs()
 
+	groupsFile := filepath.Join(configDirectory, "groups.json")
+	if pathExists(groupsFile) {
+		groupRules, err = loadGroupRules(groupsFile)
+		if err != nil {
+			log.Warnf("Couldn't load grouping rules from %s: %s", groupsFile, err)
+		} else {
+			log.Infof("Loaded %v grouping rule(s)", len(groupRules))
+		}
+	}
+
+	if *pinProfile != "" {
+		manualProfile = *pinProfile
+	}
+	profilesFile := filepath.Join(configDirectory, "profiles.json")
+	if pathExists(profilesFile) {
+		profileRules, err = loadProfileRules(profilesFile)
+		if err != nil {
+			log.Warnf("Couldn't load pin profile rules from %s: %s", profilesFile, err)
+		} else {
+			log.Infof("Loaded %v pin profile rule(s)", len(profileRules))
+		}
+	}
+
+	if *replayFile != "" {
+		backend, err = newReplayBackend(*replayFile)
+	} else {
+		backend, err = newBackend(*backendName)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Debugf("Using %T", backend)
+
+	if *recordFile != "" {
+		backend, err = newRecordingBackend(backend, *recordFile)
+		if err != nil {
+			log.Fatalf("Unable to record: %s", err)
+		}
+		log.Infof("Recording to %s", *recordFile)
+	}
+
+	usePinProfile(currentProfile())
+
+	if !*noThumbnails {
+		connectThumbnailer()
+	}
+
 	gtk.Init(nil)
 
+	screen, _ := gdk.ScreenGetDefault()
+
+	// built-in rules for task button states; the priority is lower than the one of style.css, that may override them
+	defaultCssProvider, _ := gtk.CssProviderNew()
+	if err := defaultCssProvider.LoadFromData(defaultStyle); err != nil {
+		log.Warnf("Unable to load default style: %s", err)
+	} else {
+		gtk.AddProviderForScreen(screen, defaultCssProvider, gtk.STYLE_PROVIDER_PRIORITY_SETTINGS)
+	}
+
 	cssProvider, _ := gtk.CssProviderNew()
 
 	err = cssProvider.LoadFromPath(cssFile)
@@ -466,8 +581,34 @@ func main() {
 		log.Warnf("%s file not found, using GTK styling\n", cssFile)
 	} else {
 		log.Printf("Using style: %s\n", cssFile)
-		screen, _ := gdk.ScreenGetDefault()
-		gtk.AddProviderForScreen(screen, cssProvider, gtk.STYLE_PROVIDER_PRIORITY_APPLICATION)
+	}
+	// added anyway, so that the style may be created or fixed while we're running
+	gtk.AddProviderForScreen(screen, cssProvider, gtk.STYLE_PROVIDER_PRIORITY_APPLICATION)
+
+	// reload the style, and pinned items, edited outside the dock
+	err = watchDirs([]string{configDirectory, filepath.Dir(cssFile)}, func(path string) {
+		name := filepath.Base(path)
+		if path == cssFile {
+			glib.TimeoutAdd(0, func() bool {
+				if err := cssProvider.LoadFromPath(cssFile); err != nil {
+					log.Warnf("Unable to reload %s: %s", cssFile, err)
+				} else {
+					log.Infof("Style reloaded: %s", cssFile)
+				}
+				return false
+			})
+		} else if strings.HasPrefix(name, "pinned") && strings.HasSuffix(name, ".json") {
+			glib.TimeoutAdd(0, func() bool {
+				if path == pinnedFile && reloadPins() {
+					log.Debugf("%s changed, refreshing", path)
+					refreshMainBoxChannel <- struct{}{}
+				}
+				return false
+			})
+		}
+	})
+	if err != nil {
+		log.Warnf("Unable to watch %s for changes: %s", configDirectory, err)
 	}
 
 	win, err = gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
@@ -569,53 +710,145 @@ func main() {
 	mainBox, _ = gtk.BoxNew(innerOrientation, 0)
 	// We'll pack mainBox later, in buildMainBox
 
+	// w/o the compositor we start empty, in the disconnected state, and fill up on reconnection
 	tasks, err := listTasks()
 	if err != nil {
-		log.Fatal("Couldn't list tasks:", err)
+		log.Errorf("Couldn't list tasks: %s", err)
 	}
 	oldTasks = tasks
-	var oldWsNum int64
+	var oldWsName string
 
 	buildMainBox(tasks, alignmentBox)
 
-	refreshMainBox := func(currentTasks []task, forceRefresh bool) {
-		if forceRefresh || (len(currentTasks) != len(oldTasks) || currentWsNum != oldWsNum) {
-			glib.TimeoutAdd(0, func() bool {
-				log.Debug("refreshing...")
-				buildMainBox(currentTasks, alignmentBox)
-				oldTasks = currentTasks
-				oldWsNum = currentWsNum
-				targetWsNum = currentWsNum
-				return false
-			})
+	// rebuild if the layout changes, or just refresh affected buttons
+	refresh := &refresher{render: func(req refreshRequest) {
+		currentTasks, wsName := taskList.list(), taskList.workspace()
+		if req.rebuild || wsName != oldWsName ||
+			!slices.Equal(taskGroupIDs(visibleTasks(currentTasks)), taskGroupIDs(visibleTasks(oldTasks))) {
+			log.Debug("refreshing...")
+			buildMainBox(currentTasks, alignmentBox)
+			oldWsName = wsName
+		} else {
+			for conID := range req.conIDs {
+				log.Debugf("refreshing buttons of con %v", conID)
+				refreshTaskButtons(currentTasks, conID)
+			}
+			// window counts and urgency may have changed
+			if req.workspaces || len(req.conIDs) > 0 {
+				refreshWorkspaceStrip()
+			}
 		}
-	}
+		oldTasks = currentTasks
+	}}
 
 	go func() {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		taskChannel, err := getTaskChangesChannel(ctx)
-		if err != nil {
-			log.Fatal("Unable to process sway tasks:", err)
+		// nil channels block forever
+		var taskChannel <-chan taskDiff
+		var wsChannel <-chan string
+		// In the current workspace mode we need to rebuild on workspace focus changes, the workspace strip
+		// needs refreshing on any workspace change, and pin profile rules may apply
+		followWorkspaces := *currentWsTasks || *wsStrip || len(profileRules) > 0
+
+		// failed subscriptions get retried, e.g. when the compositor is not there yet
+		var retry <-chan time.Time
+		subscribe := func() {
+			var err error
+			if taskChannel == nil {
+				if taskChannel, err = taskEvents.subscribe(ctx); err != nil {
+					log.Errorf("Unable to process tasks: %s, retrying", err)
+				}
+			}
+			if wsChannel == nil && followWorkspaces {
+				if wsChannel, err = workspaceEvents.subscribe(ctx); err != nil {
+					log.Errorf("Unable to follow workspace changes: %s, retrying", err)
+				}
+			}
+			if taskChannel == nil || (wsChannel == nil && followWorkspaces) {
+				retry = time.After(subscribeRetryDelay)
+			}
+		}
+		subscribe()
+
+		// all the refresh triggers below get coalesced here, and flushed at most once per -rd window
+		var pending refreshRequest
+		var flush <-chan time.Time
+
+		// pinnedFile gets switched on the GTK main loop, before the rebuild
+		profile := activeProfile
+		switchProfile := func(name string) {
+			if name == profile {
+				return
+			}
+			profile = name
+			glib.TimeoutAdd(0, func() bool {
+				usePinProfile(name)
+				return false
+			})
+			pending.rebuild = true
 		}
 
 		for {
 			select {
 
+			// Changes may have been missed until subscribed
+			case <-retry:
+				retry = nil
+				subscribe()
+				pending.relist = true
+
 			// Refresh if any pin/unpin action happened
 			case <-refreshMainBoxChannel:
-				currentTasks, err := listTasks()
-				if err != nil {
-					log.Fatal("Unable to retrieve task list from sway!")
+				pending.relist = true
+
+			// Grey the dock out while the compositor is gone, and catch up when it's back
+			case connected := <-connectionStateChannel:
+				glib.TimeoutAdd(0, func() bool {
+					setDisconnectedState(outerBox, !connected)
+					return false
+				})
+				if connected {
+					pending.relist = true
 				}
 
-				refreshMainBox(currentTasks, true)
+			// Apply window changes
+			case diff := <-taskChannel:
+				if diff.Op == tasksReset {
+					pending.rebuild = true
+				} else {
+					pending.addCon(diff.Task.conID)
+				}
+
+			// Refresh if the focused workspace changes, and only tasks from it are shown
+			case wsName := <-wsChannel:
+				if taskList.setWorkspace(wsName) && *currentWsTasks {
+					pending.rebuild = true
+				}
+				pending.workspaces = *wsStrip
+				if len(profileRules) > 0 {
+					switchProfile(currentProfile())
+				}
 
-			// Refresh if the state of the workspace changes
-			case currentTasks := <-taskChannel:
-				refreshMainBox(currentTasks, false)
+			// Switch the pin profile on request of another instance
+			case name := <-profileChannel:
+				if !validProfile(name) {
+					log.Warnf("Invalid pin profile name: '%s'", name)
+					continue
+				}
+				manualProfile = name
+				switchProfile(currentProfile())
+
+			case <-flush:
+				flush = nil
+				refresh.flush(pending)
+				pending = refreshRequest{}
+				continue
+			}
 
+			if flush == nil && !pending.empty() {
+				flush = time.After(time.Duration(*refreshDelay) * time.Millisecond)
 			}
 		}
 	}()
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// w/o a display, tests building widgets get skipped
+var gtkAvailable bool
+
+func TestMain(m *testing.M) {
+	gtkAvailable = gtk.InitCheck(nil) == nil
+	os.Exit(m.Run())
+}
+
+func requireGtk(t *testing.T) {
+	if !gtkAvailable {
+		t.Skip("no display to run GTK on")
+	}
+}
+
+// sets the global (e.g. a flag value) until the test ends
+func setGlobal[T any](t *testing.T, p *T, v T) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hasClass(w gtk.IWidget, class string) bool {
+	ctx, err := w.ToWidget().GetStyleContext()
+	return err == nil && ctx.HasClass(class)
+}
+
+// the button of a task or pinned item box
+func boxButton(t *testing.T, box *gtk.Box) *gtk.Widget {
+	children := box.GetChildren()
+	if children == nil || children.Length() == 0 {
+		t.Fatal("empty item box")
+	}
+	return children.NthData(0).(*gtk.Widget)
+}
+
+func TestBuildMainBox(t *testing.T) {
+	requireGtk(t)
+
+	dir := t.TempDir()
+	setGlobal(t, &pinnedFile, filepath.Join(dir, "pinned.json"))
+	setGlobal(t, &pinned, nil)
+	setGlobal(t, &taskOrder, nil)
+	setGlobal(t, &groupRules, nil)
+	setGlobal(t, &backend, Backend(&fakeBackend{}))
+	writeFile(t, pinnedFile, `[{"id": "firefox"}, {"id": "gimp"}]`)
+	reloadPins()
+
+	tasks := []task{
+		{conID: 1, ID: "firefox", Name: "Mozilla Firefox", WsNum: 1, WsName: "1"},
+		{conID: 2, ID: "foot", Name: "htop", WsNum: 1, WsName: "1", Urgent: true},
+		{conID: 3, ID: "firefox", Name: "GitHub", WsNum: 2, WsName: "2", Focused: true},
+	}
+
+	vbox, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
+	mainBox, _ = gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 0)
+	buildMainBox(tasks, vbox)
+
+	// pinned items come first, in the pinned order, running or not; then unpinned tasks, one button per group
+	children := mainBox.GetChildren()
+	if children == nil || children.Length() != 3 {
+		t.Fatalf("mainBox children: %v, want 3", children.Length())
+	}
+	firefox, foot := taskButtons["firefox"], taskButtons["foot"]
+	if firefox == nil || foot == nil || len(taskButtons) != 2 {
+		t.Fatalf("task buttons: %v", taskButtons)
+	}
+	if len(firefox.instances) != 2 || len(foot.instances) != 1 {
+		t.Errorf("instances: firefox %v, foot %v", len(firefox.instances), len(foot.instances))
+	}
+	if children.NthData(0).(*gtk.Widget).Native() != firefox.box.Native() ||
+		children.NthData(2).(*gtk.Widget).Native() != foot.box.Native() {
+		t.Error("buttons out of order")
+	}
+
+	if button := boxButton(t, firefox.box); !hasClass(button, "active") || hasClass(button, "urgent") {
+		t.Error("firefox button should be active, not urgent")
+	}
+	if button := boxButton(t, foot.box); hasClass(button, "active") || !hasClass(button, "urgent") {
+		t.Error("foot button should be urgent, not active")
+	}
+
+	// rebuilds use the pinned items we have; the file is only read again when it changes
+	if err := os.Remove(pinnedFile); err != nil {
+		t.Fatal(err)
+	}
+	buildMainBox(tasks, vbox)
+	if len(pinned) != 2 || mainBox.GetChildren().Length() != 3 {
+		t.Errorf("rebuilt w/o pinned.json: %v pinned, %v buttons", len(pinned), mainBox.GetChildren().Length())
+	}
+}
+
+func TestTaskButton(t *testing.T) {
+	requireGtk(t)
+
+	setGlobal(t, &pinned, nil)
+	setGlobal(t, &groupRules, nil)
+	setGlobal(t, &backend, Backend(&fakeBackend{}))
+	setGlobal(t, attention, "bounce")
+
+	instances := []task{
+		{conID: 1, ID: "foot", Name: "htop"},
+		{conID: 2, ID: "foot", Name: "vim", Urgent: true},
+	}
+	button := boxButton(t, taskButton(instances[0], instances))
+	if !hasClass(button, "urgent") || !hasClass(button, "attention-bounce") {
+		t.Error("an instance urgent: the button should be urgent, and animated")
+	}
+
+	instances[1].Urgent = false
+	button = boxButton(t, taskButton(instances[0], instances))
+	if hasClass(button, "urgent") || hasClass(button, "active") {
+		t.Error("no instance urgent nor focused: the button should be neither")
+	}
+
+	instances[1].Focused = true
+	button = boxButton(t, taskButton(instances[0], instances))
+	if !hasClass(button, "active") {
+		t.Error("an instance focused: the button should be active")
+	}
+
+	instances[0].Minimized = true
+	instances[1].Minimized = true
+	instances[1].Focused = false
+	button = boxButton(t, taskButton(instances[0], instances))
+	if !hasClass(button, "minimized") || hasClass(button, "active") {
+		t.Error("all instances minimized, none focused: the button should be minimized only")
+	}
+}
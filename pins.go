@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+/*
+Pinned items are kept in the ~/.config/nwg-dock/pinned.json file, in the order they appear in the dock, e.g.:
+
+	[
+		{"id": "firefox"},
+		{"id": "notes", "command": "code .", "dir": "~/notes", "env": {"GTK_THEME": "Adwaita:dark"}},
+		{"command": "foot -e htop", "icon": "utilities-system-monitor", "label": "htop", "app_id": "^foot$", "title": "^htop"},
+		{"command": "~/bin/backup.sh", "icon": "~/.icons/backup.svg", "label": "Backup"}
+	]
+
+The "id" is a .desktop file name (w/o the extension), or the app_id of the app. The optional "command" gets
+launched by the shell instead of the Exec line of the .desktop file. Either is started in the "dir" working
+directory, with "env" variables added. "icon" (a name or a path) and "label" (the tooltip) override the .desktop
+file values.
+
+Pins with a "command" are command pins: they never look into .desktop files, and don't need the "id", which then
+defaults to the command. Running windows, whose ID matches the "app_id", and title matches the "title" regular
+expression (either may be omitted), belong to the pin.
+*/
+type pin struct {
+	ID      string            `json:"id,omitempty"`
+	Command string            `json:"command,omitempty"`
+	Dir     string            `json:"dir,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	Icon    string            `json:"icon,omitempty"`
+	Label   string            `json:"label,omitempty"`
+	AppID   string            `json:"app_id,omitempty"`
+	Title   string            `json:"title,omitempty"`
+
+	appID *regexp.Regexp
+	title *regexp.Regexp
+}
+
+// the id derived from the command stays in memory; it's not written back to the file
+func (p pin) MarshalJSON() ([]byte, error) {
+	type plainPin pin
+	if p.Command != "" && p.ID == strings.TrimSpace(p.Command) {
+		p.ID = ""
+	}
+	return json.Marshal(plainPin(p))
+}
+
+// icon of command pins w/o one given
+const commandPinIcon = "application-x-executable"
+
+func loadPins(path string) ([]*pin, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parsePins(data)
+}
+
+func parsePins(data []byte) ([]*pin, error) {
+	var pins []*pin
+	err := json.Unmarshal(data, &pins)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, p := range pins {
+		p.ID = strings.TrimSpace(p.ID)
+		if p.ID == "" {
+			p.ID = strings.TrimSpace(p.Command)
+		}
+		if p.ID == "" {
+			return nil, fmt.Errorf("pin %v: neither id nor command given", i+1)
+		}
+		if p.AppID != "" {
+			if p.appID, err = regexp.Compile(p.AppID); err != nil {
+				return nil, fmt.Errorf("pin %v: %s", i+1, err)
+			}
+		}
+		if p.Title != "" {
+			if p.title, err = regexp.Compile(p.Title); err != nil {
+				return nil, fmt.Errorf("pin %v: %s", i+1, err)
+			}
+		}
+	}
+	return pins, nil
+}
+
+// writes a temporary file, and renames it over the old one, so that readers never see a half-written file
+func savePins(path string, pins []*pin) error {
+	data, err := encodePins(pins)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".pinned-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// the file contents savePins writes
+func encodePins(pins []*pin) ([]byte, error) {
+	if pins == nil {
+		pins = []*pin{}
+	}
+	data, err := json.MarshalIndent(pins, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+/*
+Applies a change to pinned items, on top of what the file holds right now, not of what we loaded on the last
+rebuild; the file may have been edited in the meantime. Scripts may take the same lock, e.g. with
+`flock ~/.config/nwg-dock/pinned.json.lock <command>`, to make sure none of the edits gets lost.
+*/
+func updatePins(path string, change func(pins []*pin) []*pin) ([]*pin, error) {
+	lock, err := os.OpenFile(fmt.Sprintf("%s.lock", path), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	// a broken file is left for the user to fix, rather than overwritten
+	pins, err := loadPins(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	pins = change(pins)
+	if err := savePins(path, pins); err != nil {
+		return nil, err
+	}
+	return pins, nil
+}
+
+// converts the plain list of IDs, used by older versions in the cache dir, to the pinned.json file
+func migratePins(oldPath, path string) {
+	if pathExists(path) || !pathExists(oldPath) {
+		return
+	}
+
+	ids, err := loadTextFile(oldPath)
+	if err != nil {
+		log.Warnf("Unable to migrate pinned items: %s", err)
+		return
+	}
+	pins := []*pin{}
+	for _, id := range ids {
+		pins = append(pins, &pin{ID: id})
+	}
+	if err := savePins(path, pins); err != nil {
+		log.Warnf("Unable to migrate pinned items: %s", err)
+		return
+	}
+	log.Infof("Pinned items migrated from %s to %s", oldPath, path)
+}
+
+/*
+Reads pinnedFile again, on the GTK main loop, and tells if the pinned items changed. The file holding just what we
+have, e.g. after our own save, is not a change; a half-edited one keeps the items we have, until it's fixed.
+*/
+func reloadPins() bool {
+	data, err := os.ReadFile(pinnedFile)
+	if os.IsNotExist(err) {
+		changed := pinned != nil
+		pinned = nil
+		return changed
+	}
+	if err != nil {
+		log.Warnf("Unable to load %s, keeping pinned items: %s", pinnedFile, err)
+		return false
+	}
+
+	if current, err := encodePins(pinned); err == nil && bytes.Equal(data, current) {
+		return false
+	}
+	pins, err := parsePins(data)
+	if err != nil {
+		log.Warnf("Unable to load %s, keeping pinned items: %s", pinnedFile, err)
+		return false
+	}
+	pinned = pins
+	return true
+}
+
+func pinFor(key string) *pin {
+	for _, p := range pinned {
+		if sameGroup(p.ID, key) {
+			return p
+		}
+	}
+	return nil
+}
+
+// the pin the task belongs to by the "app_id" and "title" expressions, if any
+func pinMatching(t task) *pin {
+	for _, p := range pinned {
+		if p.appID == nil && p.title == nil {
+			continue
+		}
+		if (p.appID == nil || p.appID.MatchString(t.ID)) && (p.title == nil || p.title.MatchString(t.Name)) {
+			return p
+		}
+	}
+	return nil
+}
+
+// command pins never look into .desktop files
+func (p *pin) isCommand() bool {
+	return p.Command != ""
+}
+
+// the icon given, with "~" expanded in paths
+func (p *pin) icon() string {
+	if home, err := os.UserHomeDir(); err == nil && strings.HasPrefix(p.Icon, "~/") {
+		return filepath.Join(home, p.Icon[2:])
+	}
+	return p.Icon
+}
+
+// unlike createPixbuf, doesn't fall back to .desktop files
+func (p *pin) pixbuf(size int) (*gdk.Pixbuf, error) {
+	icon := p.icon()
+	if icon == "" {
+		icon = commandPinIcon
+	}
+	if strings.HasPrefix(icon, "/") {
+		return gdk.PixbufNewFromFileAtSize(icon, size, size)
+	}
+	iconTheme, err := gtk.IconThemeGetDefault()
+	if err != nil {
+		return nil, err
+	}
+	return iconTheme.LoadIcon(icon, size, gtk.ICON_LOOKUP_FORCE_SIZE)
+}
+
+// sets the pin's working directory, and adds its environment variables to the command
+func (p *pin) setup(cmd *exec.Cmd) {
+	if p.Dir != "" {
+		dir := p.Dir
+		if home, err := os.UserHomeDir(); err == nil && (dir == "~" || strings.HasPrefix(dir, "~/")) {
+			dir = filepath.Join(home, dir[1:])
+		}
+		cmd.Dir = dir
+	}
+	if len(p.Env) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		for k, v := range p.Env {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+}
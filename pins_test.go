@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func pinIDs(pins []*pin) []string {
+	var ids []string
+	for _, p := range pins {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func TestUpdatePins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pinned.json")
+
+	// w/o the file, the change starts from scratch
+	pins, err := updatePins(path, func(pins []*pin) []*pin {
+		if pins != nil {
+			t.Errorf("pins w/o the file = %v", pinIDs(pins))
+		}
+		return append(pins, &pin{ID: "firefox"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := pinIDs(pins); !equalStrings(ids, "firefox") {
+		t.Errorf("pins = %v", ids)
+	}
+
+	// the change applies on top of what the file holds now, edited meanwhile
+	writeFile(t, path, `[{"id": "firefox"}, {"command": "foot -e htop", "app_id": "^foot$"}]`)
+	pins, err = updatePins(path, func(pins []*pin) []*pin {
+		return append(pins, &pin{ID: "gimp"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := loadPins(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := pinIDs(saved); !equalStrings(ids, "firefox", "foot -e htop", "gimp") || !equalStrings(pinIDs(pins), ids...) {
+		t.Errorf("saved = %v, returned %v", ids, pinIDs(pins))
+	}
+	if saved[1].appID == nil || !saved[1].appID.MatchString("foot") {
+		t.Error("app_id expression not compiled")
+	}
+
+	// a broken file is left for the user to fix
+	broken := `[{"id": "firefox"}, {"id": `
+	writeFile(t, path, broken)
+	_, err = updatePins(path, func(pins []*pin) []*pin {
+		t.Error("change applied to a broken file")
+		return pins
+	})
+	if err == nil {
+		t.Error("no error for a broken file")
+	}
+	if data, _ := os.ReadFile(path); string(data) != broken {
+		t.Errorf("broken file overwritten: %s", data)
+	}
+}
+
+func TestSavePinsKeepsDerivedID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pinned.json")
+
+	writeFile(t, path, `[{"command": "foot -e htop"}, {"id": "monitor", "command": "foot -e btop"}]`)
+	pins, err := loadPins(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := pinIDs(pins); !equalStrings(ids, "foot -e htop", "monitor") {
+		t.Fatalf("loaded = %v", ids)
+	}
+	if err := savePins(path, pins); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pin w/o an id stays w/o one, the other one keeps its own
+	var saved []map[string]interface{}
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := saved[0]["id"]; ok || saved[1]["id"] != "monitor" {
+		t.Errorf("saved: %s", data)
+	}
+	if pins, _ := loadPins(path); !equalStrings(pinIDs(pins), "foot -e htop", "monitor") {
+		t.Errorf("loaded again = %v", pinIDs(pins))
+	}
+}
+
+func TestReloadPins(t *testing.T) {
+	setGlobal(t, &pinnedFile, filepath.Join(t.TempDir(), "pinned.json"))
+	setGlobal(t, &pinned, nil)
+
+	writeFile(t, pinnedFile, `[{"id": "firefox"}, {"command": "foot -e htop"}]`)
+	if !reloadPins() || !equalStrings(pinIDs(pinned), "firefox", "foot -e htop") {
+		t.Errorf("reloaded = %v", pinIDs(pinned))
+	}
+
+	// our own save brings nothing new
+	if err := savePins(pinnedFile, pinned); err != nil {
+		t.Fatal(err)
+	}
+	if reloadPins() {
+		t.Error("own save reported as a change")
+	}
+
+	// a half-edited file keeps the pinned items
+	writeFile(t, pinnedFile, `[{"id": "firefox"}, {"id": `)
+	if reloadPins() || len(pinned) != 2 {
+		t.Errorf("after a parse error: %v", pinIDs(pinned))
+	}
+
+	// while a missing one means none
+	if err := os.Remove(pinnedFile); err != nil {
+		t.Fatal(err)
+	}
+	if !reloadPins() || pinned != nil {
+		t.Errorf("w/o the file: %v", pinIDs(pinned))
+	}
+	if reloadPins() {
+		t.Error("still missing file reported as a change")
+	}
+}
+
+func TestMigratePins(t *testing.T) {
+	dir := t.TempDir()
+	oldPath, path := filepath.Join(dir, "nwg-dock-pinned"), filepath.Join(dir, "pinned.json")
+
+	// nothing to migrate
+	migratePins(oldPath, path)
+	if pathExists(path) {
+		t.Error("pinned.json created from nothing")
+	}
+
+	writeFile(t, oldPath, "firefox\n\n  foot \n")
+	migratePins(oldPath, path)
+	pins, err := loadPins(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := pinIDs(pins); !equalStrings(ids, "firefox", "foot") {
+		t.Errorf("migrated = %v", ids)
+	}
+
+	// an existing pinned.json wins
+	writeFile(t, oldPath, "gimp\n")
+	migratePins(oldPath, path)
+	if pins, _ := loadPins(path); !equalStrings(pinIDs(pins), "firefox", "foot") {
+		t.Errorf("migrated again = %v", pinIDs(pins))
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Pin profiles are separate sets of pinned items, e.g. for work and gaming. The "default" profile is kept in the
+pinned.json file, and other ones in pinned-<name>.json files. The profile gets chosen with the "-pp" argument;
+running `nwg-dock -pp <name>` while the dock is running switches the running instance.
+
+Rules in the optional ~/.config/nwg-dock/profiles.json file switch profiles automatically, e.g.:
+
+	[
+		{"workspace": "^(9|games)$", "profile": "gaming"},
+		{"output": "^HDMI-A-1$", "profile": "presentation"}
+	]
+
+"workspace" and "output" are regular expressions, matched against the focused workspace name and its output.
+The first matching rule wins; if none matches, the profile chosen with "-pp" is used.
+*/
+type profileRule struct {
+	Workspace string `json:"workspace"`
+	Output    string `json:"output"`
+	Profile   string `json:"profile"`
+
+	workspace *regexp.Regexp
+	output    *regexp.Regexp
+}
+
+const defaultProfile = "default"
+
+var (
+	profileRules  []*profileRule
+	manualProfile = defaultProfile // chosen with "-pp", or sent to the running instance
+	activeProfile = defaultProfile // the one pinnedFile belongs to; GTK main loop only
+)
+
+func loadProfileRules(path string) ([]*profileRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*profileRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+
+	for i, r := range rules {
+		if !validProfile(r.Profile) {
+			return nil, fmt.Errorf("rule %v: invalid profile '%s'", i+1, r.Profile)
+		}
+		if r.Workspace != "" {
+			if r.workspace, err = regexp.Compile(r.Workspace); err != nil {
+				return nil, fmt.Errorf("rule %v: %s", i+1, err)
+			}
+		}
+		if r.Output != "" {
+			if r.output, err = regexp.Compile(r.Output); err != nil {
+				return nil, fmt.Errorf("rule %v: %s", i+1, err)
+			}
+		}
+		if r.workspace == nil && r.output == nil {
+			return nil, fmt.Errorf("rule %v: nothing to match", i+1)
+		}
+	}
+	return rules, nil
+}
+
+func (r *profileRule) matches(ws workspace) bool {
+	return (r.workspace == nil || r.workspace.MatchString(ws.Name)) &&
+		(r.output == nil || r.output.MatchString(ws.Output))
+}
+
+// profile names become parts of file names
+func validProfile(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "/\\") && name != "." && name != ".."
+}
+
+func profileFile(name string) string {
+	if name == defaultProfile {
+		return filepath.Join(configDirectory, "pinned.json")
+	}
+	return filepath.Join(configDirectory, fmt.Sprintf("pinned-%s.json", name))
+}
+
+// the profile to use on the focused workspace; not to be called on the GTK main loop
+func currentProfile() string {
+	if len(profileRules) == 0 {
+		return manualProfile
+	}
+	workspaces, err := backend.Workspaces()
+	if err != nil {
+		log.Warnf("Unable to list workspaces: %s", err)
+		return manualProfile
+	}
+	if focused := focusedWorkspace(workspaces); focused != nil {
+		for _, r := range profileRules {
+			if r.matches(*focused) {
+				return r.Profile
+			}
+		}
+	}
+	return manualProfile
+}
+
+// makes pinnedFile point to the profile's file, and loads its pinned items; the caller rebuilds mainBox
+func usePinProfile(name string) {
+	if name == activeProfile && pinnedFile != "" {
+		return
+	}
+	activeProfile = name
+	pinnedFile = profileFile(name)
+	log.Infof("Using the '%s' pin profile: %s", name, pinnedFile)
+	reloadPins()
+}
+
+// the running instance reads the profile name from here, on SIGUSR2
+func profileRequestFile(lockFilePath string) string {
+	return fmt.Sprintf("%s.profile", strings.TrimSuffix(lockFilePath, ".lock"))
+}
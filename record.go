@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/joshuarubin/go-sway"
+)
+
+/*
+With --record FILE, every task list snapshot and event the dock receives from the backend (and every
+action it requests) gets written to FILE as a JSON line. With --replay FILE, a recorded session is played
+back, with the original timing, through the same channels, without a running compositor.
+
+On sway, snapshots also hold the raw tree and workspaces they come from, and the replay turns them into tasks
+again with the current code, so that bugs in reading the tree reproduce, and fixes may be checked.
+*/
+
+type recordedTask struct {
+	ConID     int64  `json:"con_id"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	PID       uint32 `json:"pid"`
+	WsNum     int64  `json:"ws_num"`
+	WsName    string `json:"ws_name,omitempty"`
+	Minimized bool   `json:"minimized,omitempty"`
+	Maximized bool   `json:"maximized,omitempty"`
+	Urgent    bool   `json:"urgent,omitempty"`
+	Focused   bool   `json:"focused,omitempty"`
+	Output    string `json:"output,omitempty"`
+}
+
+func newRecordedTask(t task) recordedTask {
+	return recordedTask{ConID: t.conID, ID: t.ID, Name: t.Name, PID: t.PID, WsNum: t.WsNum, WsName: t.WsName,
+		Minimized: t.Minimized, Maximized: t.Maximized, Urgent: t.Urgent, Focused: t.Focused, Output: t.Output}
+}
+
+func (r recordedTask) task() task {
+	return task{conID: r.ConID, ID: r.ID, Name: r.Name, PID: r.PID, WsNum: r.WsNum, WsName: r.WsName,
+		Minimized: r.Minimized, Maximized: r.Maximized, Urgent: r.Urgent, Focused: r.Focused, Output: r.Output}
+}
+
+// a single line of the record file
+type recordEntry struct {
+	Time int64 `json:"time"` // milliseconds since the start
+	// "backend", "tasks", "outputs", "workspaces", "task_event", "workspace_event" or "action"
+	Type       string         `json:"type"`
+	Backend    string         `json:"backend,omitempty"`
+	Features   []string       `json:"features,omitempty"`
+	Tasks      []recordedTask `json:"tasks,omitempty"`
+	WsName     string         `json:"ws_name,omitempty"` // the focused workspace
+	Outputs    []output       `json:"outputs,omitempty"`
+	Workspaces []workspace    `json:"workspaces,omitempty"`
+	Change     string         `json:"change,omitempty"`
+	Task       *recordedTask  `json:"task,omitempty"` // nil for events requesting a resync
+	Action     string         `json:"action,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	// what sway gave for the "tasks" snapshot
+	SwayTree       *sway.Node       `json:"sway_tree,omitempty"`
+	SwayWorkspaces []sway.Workspace `json:"sway_workspaces,omitempty"`
+}
+
+var featureNames = map[feature]string{
+	featureWorkspaces: "workspaces",
+	featureMinimize:   "minimize",
+	featureMaximize:   "maximize",
+	featureScratchpad: "scratchpad",
+}
+
+// recordingBackend passes everything through to the real backend, writing it down on the way
+type recordingBackend struct {
+	Backend
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	start   time.Time
+}
+
+func newRecordingBackend(b Backend, path string) (*recordingBackend, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &recordingBackend{Backend: b, file: file, encoder: json.NewEncoder(file), start: time.Now()}
+
+	entry := recordEntry{Type: "backend", Backend: fmt.Sprintf("%T", b)}
+	for f, name := range featureNames {
+		if b.Supports(f) {
+			entry.Features = append(entry.Features, name)
+		}
+	}
+	sort.Strings(entry.Features)
+	r.write(entry)
+	return r, nil
+}
+
+func (r *recordingBackend) write(entry recordEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry.Time = time.Since(r.start).Milliseconds()
+	if err := r.encoder.Encode(entry); err != nil {
+		log.Warnf("Unable to record: %s", err)
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func (r *recordingBackend) ListTasks() ([]task, string, error) {
+	var (
+		tasks  []task
+		wsName string
+		err    error
+		entry  = recordEntry{Type: "tasks"}
+	)
+	if b, ok := r.Backend.(*swayBackend); ok {
+		tasks, wsName, entry.SwayTree, entry.SwayWorkspaces, err = b.listTasksTree()
+	} else {
+		tasks, wsName, err = r.Backend.ListTasks()
+	}
+	entry.WsName = wsName
+	entry.Error = errorString(err)
+	for _, t := range tasks {
+		entry.Tasks = append(entry.Tasks, newRecordedTask(t))
+	}
+	r.write(entry)
+	return tasks, wsName, err
+}
+
+// the embedded Backend hides it, and thumbnails would fall back to matching windows by app_id and title
+func (r *recordingBackend) ToplevelIdentifiers() (map[int64]string, error) {
+	if b, ok := r.Backend.(toplevelIdentifier); ok {
+		return b.ToplevelIdentifiers()
+	}
+	return nil, errNotSupported
+}
+
+func (r *recordingBackend) Outputs() ([]output, error) {
+	outputs, err := r.Backend.Outputs()
+	r.write(recordEntry{Type: "outputs", Outputs: outputs, Error: errorString(err)})
+	return outputs, err
+}
+
+func (r *recordingBackend) Workspaces() ([]workspace, error) {
+	workspaces, err := r.Backend.Workspaces()
+	r.write(recordEntry{Type: "workspaces", Workspaces: workspaces, Error: errorString(err)})
+	return workspaces, err
+}
+
+func (r *recordingBackend) TaskEvents(ctx context.Context) (<-chan TaskChange, error) {
+	taskUpdateChannel, err := r.Backend.TaskEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recordedChannel := make(chan TaskChange, 1)
+	go func() {
+		for change := range taskUpdateChannel {
+			entry := recordEntry{Type: "task_event", Change: change.Change}
+			if change.Task != nil {
+				t := newRecordedTask(*change.Task)
+				entry.Task = &t
+			}
+			r.write(entry)
+			recordedChannel <- change
+		}
+	}()
+	return recordedChannel, nil
+}
+
+func (r *recordingBackend) WorkspaceEvents(ctx context.Context) (<-chan string, error) {
+	workspaceUpdateChannel, err := r.Backend.WorkspaceEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recordedChannel := make(chan string, 1)
+	go func() {
+		for wsName := range workspaceUpdateChannel {
+			r.write(recordEntry{Type: "workspace_event", WsName: wsName})
+			recordedChannel <- wsName
+		}
+	}()
+	return recordedChannel, nil
+}
+
+func (r *recordingBackend) action(action string, err error) error {
+	r.write(recordEntry{Type: "action", Action: action, Error: errorString(err)})
+	return err
+}
+
+func (r *recordingBackend) FocusCon(conID int64) error {
+	return r.action(fmt.Sprintf("focus %v", conID), r.Backend.FocusCon(conID))
+}
+
+func (r *recordingBackend) KillCon(conID int64) error {
+	return r.action(fmt.Sprintf("kill %v", conID), r.Backend.KillCon(conID))
+}
+
+func (r *recordingBackend) MoveConToWorkspace(conID int64, wsNum int) error {
+	return r.action(fmt.Sprintf("move %v to workspace %v", conID, wsNum), r.Backend.MoveConToWorkspace(conID, wsNum))
+}
+
+func (r *recordingBackend) FocusWorkspace(name string) error {
+	return r.action(fmt.Sprintf("focus workspace %s", name), r.Backend.FocusWorkspace(name))
+}
+
+func (r *recordingBackend) SetMinimized(conID int64, minimized bool) error {
+	return r.action(fmt.Sprintf("set %v minimized=%v", conID, minimized), r.Backend.SetMinimized(conID, minimized))
+}
+
+func (r *recordingBackend) SetMaximized(conID int64, maximized bool) error {
+	return r.action(fmt.Sprintf("set %v maximized=%v", conID, maximized), r.Backend.SetMaximized(conID, maximized))
+}
+
+/*
+replayBackend plays a recorded session back. Events get sent with the recorded timing, once the dock
+subscribes to task events. ListTasks returns the latest snapshot reached; an event requesting a resync
+brings the snapshot that followed it in the recording. Actions only get logged.
+*/
+type replayBackend struct {
+	entries     []recordEntry
+	features    []string
+	outputs     []output
+	mu          sync.Mutex
+	snapshot    *recordEntry
+	workspaces  *recordEntry
+	once        sync.Once
+	taskChannel chan TaskChange
+	wsChannels  []chan string
+}
+
+func newReplayBackend(path string) (*replayBackend, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	b := &replayBackend{taskChannel: make(chan TaskChange, 1)}
+	scanner := bufio.NewScanner(file)
+	// tree snapshots may be long
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry recordEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%v: %s", path, line, err)
+		}
+		b.entries = append(b.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range b.entries {
+		entry := &b.entries[i]
+		switch entry.Type {
+		case "backend":
+			b.features = entry.Features
+			log.Infof("Replaying %s, recorded with %s", path, entry.Backend)
+		case "outputs":
+			if b.outputs == nil {
+				b.outputs = entry.Outputs
+			}
+		case "tasks":
+			if b.snapshot == nil {
+				b.snapshot = entry
+			}
+		case "workspaces":
+			if b.workspaces == nil {
+				b.workspaces = entry
+			}
+		}
+	}
+	return b, nil
+}
+
+func (b *replayBackend) ListTasks() ([]task, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.snapshot == nil {
+		return nil, "", nil
+	}
+	if b.snapshot.Error != "" {
+		return nil, "", fmt.Errorf("recorded: %s", b.snapshot.Error)
+	}
+	if b.snapshot.SwayTree != nil {
+		tasks, wsName, _ := swayTasks(b.snapshot.SwayTree, b.snapshot.SwayWorkspaces)
+		return tasks, wsName, nil
+	}
+	var tasks []task
+	for _, t := range b.snapshot.Tasks {
+		tasks = append(tasks, t.task())
+	}
+	return tasks, b.snapshot.WsName, nil
+}
+
+// returns the latest workspace list reached
+func (b *replayBackend) Workspaces() ([]workspace, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.workspaces == nil {
+		return nil, nil
+	}
+	if b.workspaces.Error != "" {
+		return nil, fmt.Errorf("recorded: %s", b.workspaces.Error)
+	}
+	return b.workspaces.Workspaces, nil
+}
+
+func (b *replayBackend) Outputs() ([]output, error) {
+	return b.outputs, nil
+}
+
+func (b *replayBackend) TaskEvents(ctx context.Context) (<-chan TaskChange, error) {
+	b.once.Do(func() {
+		go b.play(ctx)
+	})
+	return b.taskChannel, nil
+}
+
+func (b *replayBackend) WorkspaceEvents(ctx context.Context) (<-chan string, error) {
+	workspaceUpdateChannel := make(chan string, 1)
+	b.mu.Lock()
+	b.wsChannels = append(b.wsChannels, workspaceUpdateChannel)
+	b.mu.Unlock()
+	return workspaceUpdateChannel, nil
+}
+
+func (b *replayBackend) play(ctx context.Context) {
+	start := time.Now()
+	for i := range b.entries {
+		entry := &b.entries[i]
+		if wait := time.Duration(entry.Time)*time.Millisecond - time.Since(start); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		switch entry.Type {
+		case "tasks":
+			b.setSnapshot(entry)
+		case "workspaces":
+			b.mu.Lock()
+			b.workspaces = entry
+			b.mu.Unlock()
+		case "task_event":
+			change := TaskChange{Change: entry.Change}
+			if entry.Task != nil {
+				t := entry.Task.task()
+				change.Task = &t
+			} else if next := b.nextSnapshot(i); next != nil {
+				// the dock is about to re-list tasks, and the recording has the result
+				b.setSnapshot(next)
+			}
+			select {
+			case b.taskChannel <- change:
+			case <-ctx.Done():
+				return
+			}
+		case "workspace_event":
+			b.mu.Lock()
+			wsChannels := b.wsChannels
+			b.mu.Unlock()
+			// each subscriber gets every event, as from a live compositor
+			for _, ch := range wsChannels {
+				select {
+				case ch <- entry.WsName:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+	log.Info("Replay finished")
+}
+
+func (b *replayBackend) setSnapshot(entry *recordEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.snapshot = entry
+}
+
+// returns the snapshot recorded after the i-th entry, before the next event
+func (b *replayBackend) nextSnapshot(i int) *recordEntry {
+	for j := i + 1; j < len(b.entries); j++ {
+		switch b.entries[j].Type {
+		case "tasks":
+			return &b.entries[j]
+		case "task_event", "workspace_event":
+			return nil
+		}
+	}
+	return nil
+}
+
+func (b *replayBackend) Supports(f feature) bool {
+	return isIn(b.features, featureNames[f])
+}
+
+func (b *replayBackend) FocusCon(conID int64) error {
+	log.Infof("Replay: focus %v", conID)
+	return nil
+}
+
+func (b *replayBackend) KillCon(conID int64) error {
+	log.Infof("Replay: kill %v", conID)
+	return nil
+}
+
+func (b *replayBackend) MoveConToWorkspace(conID int64, wsNum int) error {
+	log.Infof("Replay: move %v to workspace %v", conID, wsNum)
+	return nil
+}
+
+func (b *replayBackend) FocusWorkspace(name string) error {
+	log.Infof("Replay: focus workspace %s", name)
+	return nil
+}
+
+func (b *replayBackend) SetMinimized(conID int64, minimized bool) error {
+	log.Infof("Replay: set %v minimized=%v", conID, minimized)
+	return nil
+}
+
+func (b *replayBackend) SetMaximized(conID int64, maximized bool) error {
+	log.Infof("Replay: set %v maximized=%v", conID, maximized)
+	return nil
+}
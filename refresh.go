@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gotk3/gotk3/glib"
+)
+
+/*
+refreshRequest collects what needs refreshing. Events coming within the -rd window are merged into
+a single request, which then gets rendered once, on the latest task list.
+*/
+type refreshRequest struct {
+	relist     bool           // re-list tasks from the backend first, e.g. after a pin/unpin action
+	rebuild    bool           // rebuild the whole mainBox
+	workspaces bool           // refresh the workspace strip
+	conIDs     map[int64]bool // cons whose task buttons need refreshing
+}
+
+func (r *refreshRequest) empty() bool {
+	return !r.relist && !r.rebuild && !r.workspaces && len(r.conIDs) == 0
+}
+
+func (r *refreshRequest) addCon(conID int64) {
+	if r.conIDs == nil {
+		r.conIDs = make(map[int64]bool)
+	}
+	r.conIDs[conID] = true
+}
+
+func (r *refreshRequest) merge(other refreshRequest) {
+	r.relist = r.relist || other.relist
+	r.rebuild = r.rebuild || other.rebuild
+	r.workspaces = r.workspaces || other.workspaces
+	for conID := range other.conIDs {
+		r.addCon(conID)
+	}
+}
+
+/*
+refresher makes sure at most one render is queued on the GTK main loop at a time. Requests
+scheduled while one is waiting are merged into it, instead of queueing another one.
+*/
+type refresher struct {
+	render  func(req refreshRequest) // called on the GTK main loop
+	mu      sync.Mutex
+	queued  bool
+	pending refreshRequest
+}
+
+// re-lists tasks if requested, and queues the render; not to be called on the GTK main loop
+func (r *refresher) flush(req refreshRequest) {
+	if req.relist {
+		if _, err := listTasks(); err != nil {
+			log.Errorf("Unable to retrieve task list: %s", err)
+		} else {
+			req.rebuild = true
+		}
+		req.relist = false
+	}
+	if req.empty() {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pending.merge(req)
+	if r.queued {
+		return
+	}
+	r.queued = true
+	glib.TimeoutAdd(0, func() bool {
+		r.mu.Lock()
+		req := r.pending
+		r.pending = refreshRequest{}
+		r.queued = false
+		r.mu.Unlock()
+
+		r.render(req)
+		return false
+	})
+}
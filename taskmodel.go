@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+type diffOp int
+
+const (
+	taskAdded diffOp = iota
+	taskRemoved
+	taskUpdated
+	// the whole list has been replaced, e.g. after the backend failed to tell what changed
+	tasksReset
+)
+
+// taskDiff is what subscribers of taskEvents receive, after the change has been applied to the model
+type taskDiff struct {
+	Op   diffOp
+	Task task // the new state, or the removed task
+	Old  task // the previous state, for taskUpdated
+}
+
+// taskModel keeps the in-memory task list up to date by applying TaskChange events, instead of re-listing the whole tree
+type taskModel struct {
+	mu     sync.Mutex
+	tasks  []task
+	wsName string // the focused workspace, as last listed or reported by workspace events
+}
+
+func (m *taskModel) reset(tasks []task, wsName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tasks = append([]task(nil), tasks...)
+	m.wsName = wsName
+}
+
+func (m *taskModel) workspace() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.wsName
+}
+
+// returns false if the workspace was focused already
+func (m *taskModel) setWorkspace(wsName string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if wsName == m.wsName {
+		return false
+	}
+	m.wsName = wsName
+	return true
+}
+
+// returns a copy of the task list, sorted by workspace numbers
+func (m *taskModel) list() []task {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]task(nil), m.tasks...)
+}
+
+/*
+Applies the change, and returns the resulting diffs. The "new" change adds the task, "close" removes it,
+any other change replaces the task with the same conID, or adds it if unknown. Only "new" and "move" may
+bring a different workspace, so for other changes the known WsNum, WsName and Output are kept. A newly focused
+task takes the focus from the others, which brings additional diffs. Returns nil if nothing changed.
+*/
+func (m *taskModel) apply(change TaskChange) []taskDiff {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := *change.Task
+	idx := -1
+	for i, existing := range m.tasks {
+		if existing.conID == t.conID {
+			idx = i
+			break
+		}
+	}
+
+	if change.Change == "close" {
+		if idx == -1 {
+			return nil
+		}
+		removed := m.tasks[idx]
+		m.tasks = append(m.tasks[:idx], m.tasks[idx+1:]...)
+		return []taskDiff{{Op: taskRemoved, Task: removed}}
+	}
+
+	var diffs []taskDiff
+	if idx == -1 {
+		m.tasks = append(m.tasks, t)
+		diffs = append(diffs, taskDiff{Op: taskAdded, Task: t})
+	} else {
+		if change.Change != "new" && change.Change != "move" {
+			t.WsNum = m.tasks[idx].WsNum
+			t.WsName = m.tasks[idx].WsName
+			t.Output = m.tasks[idx].Output
+		}
+		if m.tasks[idx] == t {
+			return nil
+		}
+		diffs = append(diffs, taskDiff{Op: taskUpdated, Task: t, Old: m.tasks[idx]})
+		m.tasks[idx] = t
+	}
+
+	if t.Focused {
+		for i, other := range m.tasks {
+			if other.Focused && other.conID != t.conID {
+				m.tasks[i].Focused = false
+				diffs = append(diffs, taskDiff{Op: taskUpdated, Task: m.tasks[i], Old: other})
+			}
+		}
+	}
+
+	sort.SliceStable(m.tasks, func(i int, j int) bool {
+		return m.tasks[i].WsNum < m.tasks[j].WsNum
+	})
+	return diffs
+}
+
+// ordered IDs of task groups, the way buildMainBox lays them out; if they don't change, neither does the layout
+func taskGroupIDs(tasks []task) []string {
+	var ids []string
+	for _, t := range tasks {
+		if key := groupKey(t); !isIn(ids, key) {
+			ids = append(ids, key)
+		}
+	}
+	return ids
+}
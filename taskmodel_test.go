@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTaskModelWorkspace(t *testing.T) {
+	var m taskModel
+	m.reset([]task{{conID: 1, ID: "foot", WsNum: 1, WsName: "1"}}, "1")
+
+	// workspace events tell if the focus moved; re-listing brings the focused workspace along
+	if m.setWorkspace("1") {
+		t.Error("the focused workspace reported as a change")
+	}
+	if !m.setWorkspace("web") || m.workspace() != "web" {
+		t.Errorf("workspace = %s, want web", m.workspace())
+	}
+	m.reset(m.list(), "2")
+	if m.workspace() != "2" {
+		t.Errorf("workspace after reset = %s, want 2", m.workspace())
+	}
+}
+
+func TestTaskModelApply(t *testing.T) {
+	var m taskModel
+	m.reset([]task{
+		{conID: 1, ID: "foot", Name: "htop", WsNum: 1, WsName: "1", Output: "DP-1", Focused: true},
+		{conID: 2, ID: "firefox", Name: "GitHub", WsNum: 3, WsName: "3", Output: "HDMI-A-1"},
+	}, "1")
+
+	// a new task gets added
+	diffs := m.apply(TaskChange{Change: "new", Task: &task{conID: 3, ID: "gimp", WsNum: 2, WsName: "2", Output: "DP-1"}})
+	if len(diffs) != 1 || diffs[0].Op != taskAdded || diffs[0].Task.conID != 3 {
+		t.Errorf("new: %+v", diffs)
+	}
+	if ids := conIDs(m.list()); !equalIDs(ids, 1, 3, 2) {
+		t.Errorf("after new: %v, want sorted by workspace numbers", ids)
+	}
+
+	// title events don't tell the workspace, so the known one is kept
+	diffs = m.apply(TaskChange{Change: "title", Task: &task{conID: 2, ID: "firefox", Name: "Go"}})
+	if len(diffs) != 1 || diffs[0].Op != taskUpdated || diffs[0].Old.Name != "GitHub" {
+		t.Fatalf("title: %+v", diffs)
+	}
+	if got := diffs[0].Task; got.Name != "Go" || got.WsNum != 3 || got.WsName != "3" || got.Output != "HDMI-A-1" {
+		t.Errorf("title kept %+v", got)
+	}
+
+	// the same state again changes nothing
+	if diffs = m.apply(TaskChange{Change: "title", Task: &task{conID: 2, ID: "firefox", Name: "Go"}}); diffs != nil {
+		t.Errorf("no change: %+v", diffs)
+	}
+
+	// moves bring the new workspace, and keep the order
+	diffs = m.apply(TaskChange{Change: "move", Task: &task{conID: 2, ID: "firefox", Name: "Go", WsNum: 1, WsName: "1",
+		Output: "DP-1"}})
+	if len(diffs) != 1 || diffs[0].Task.WsName != "1" || diffs[0].Old.WsName != "3" {
+		t.Errorf("move: %+v", diffs)
+	}
+	if ids := conIDs(m.list()); !equalIDs(ids, 1, 2, 3) {
+		t.Errorf("after move: %v", ids)
+	}
+
+	// a focused task takes the focus from the others
+	diffs = m.apply(TaskChange{Change: "focus", Task: &task{conID: 3, ID: "gimp", Focused: true}})
+	if len(diffs) != 2 || diffs[0].Task.conID != 3 || diffs[1].Task.conID != 1 || diffs[1].Task.Focused {
+		t.Errorf("focus: %+v", diffs)
+	}
+	if got := m.list()[2]; got.conID != 3 || got.WsName != "2" {
+		t.Errorf("focus kept %+v", got)
+	}
+
+	// closing removes the task, once
+	diffs = m.apply(TaskChange{Change: "close", Task: &task{conID: 1}})
+	if len(diffs) != 1 || diffs[0].Op != taskRemoved || diffs[0].Task.Name != "htop" {
+		t.Errorf("close: %+v", diffs)
+	}
+	if diffs = m.apply(TaskChange{Change: "close", Task: &task{conID: 1}}); diffs != nil {
+		t.Errorf("close again: %+v", diffs)
+	}
+
+	// other changes of unknown tasks add them
+	diffs = m.apply(TaskChange{Change: "urgent", Task: &task{conID: 4, ID: "foot", Urgent: true}})
+	if len(diffs) != 1 || diffs[0].Op != taskAdded {
+		t.Errorf("unknown task: %+v", diffs)
+	}
+}
+
+func conIDs(tasks []task) []int64 {
+	var ids []int64
+	for _, t := range tasks {
+		ids = append(ids, t.conID)
+	}
+	return ids
+}
+
+func equalIDs(ids []int64, want ...int64) bool {
+	if len(ids) != len(want) {
+		return false
+	}
+	for i := range ids {
+		if ids[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
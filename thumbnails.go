@@ -0,0 +1,420 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+/*
+Window thumbnails for the instance popup, captured with the ext-image-copy-capture protocol into shared
+memory buffers, so that no GPU is needed. Windows are found among toplevels of the ext-foreign-toplevel-list
+by the identifier the backend knows (sway 1.11+), or by app_id and title.
+*/
+
+const (
+	thumbnailWidth      = 240
+	wlShmFormatARGB8888 = 0
+	wlShmFormatXRGB8888 = 1
+)
+
+// backends able to tell ext-foreign-toplevel-list identifiers of their cons
+type toplevelIdentifier interface {
+	// identifiers by con IDs, of all cons at once
+	ToplevelIdentifiers() (map[int64]string, error)
+}
+
+type extToplevel struct {
+	handle     uint32
+	identifier string
+	appID      string
+	title      string
+}
+
+type thumbnailer struct {
+	client        *wlClient
+	shm           uint32
+	sourceManager uint32
+	copyManager   uint32
+	mu            sync.Mutex
+	toplevels     map[uint32]*extToplevel
+	captureMu     sync.Mutex
+}
+
+var (
+	thumbnails      *thumbnailer
+	thumbnailsErr   error
+	thumbnailsReady = make(chan struct{})
+)
+
+// connects in the background at startup, as roundtrips may take a while; if the compositor lacks the
+// protocols, thumbnails stay off for good
+func connectThumbnailer() {
+	go func() {
+		thumbnails, thumbnailsErr = newThumbnailer()
+		if thumbnailsErr != nil {
+			log.Warnf("Window thumbnails unavailable: %s", thumbnailsErr)
+		}
+		close(thumbnailsReady)
+	}()
+}
+
+// never blocks the GTK main loop; until connected, popups show titles only
+func getThumbnailer() (*thumbnailer, error) {
+	select {
+	case <-thumbnailsReady:
+		return thumbnails, thumbnailsErr
+	default:
+		return nil, errors.New("not connected yet")
+	}
+}
+
+func newThumbnailer() (*thumbnailer, error) {
+	client, err := wlConnect()
+	if err != nil {
+		return nil, err
+	}
+	t := &thumbnailer{client: client, toplevels: make(map[uint32]*extToplevel)}
+
+	globals := make(map[string]wlGlobal)
+	for _, iface := range []string{"wl_shm", "ext_foreign_toplevel_list_v1",
+		"ext_foreign_toplevel_image_capture_source_manager_v1", "ext_image_copy_capture_manager_v1"} {
+		found := client.findGlobals(iface)
+		if len(found) == 0 {
+			client.close(nil)
+			return nil, fmt.Errorf("compositor doesn't support %s", iface)
+		}
+		globals[iface] = found[0]
+	}
+
+	if t.shm, err = client.bind(globals["wl_shm"], 1, nil); err != nil {
+		client.close(err)
+		return nil, err
+	}
+	if t.sourceManager, err = client.bind(globals["ext_foreign_toplevel_image_capture_source_manager_v1"], 1, nil); err != nil {
+		client.close(err)
+		return nil, err
+	}
+	if t.copyManager, err = client.bind(globals["ext_image_copy_capture_manager_v1"], 1, nil); err != nil {
+		client.close(err)
+		return nil, err
+	}
+	if _, err = client.bind(globals["ext_foreign_toplevel_list_v1"], 1, t.listEvent); err != nil {
+		client.close(err)
+		return nil, err
+	}
+	// wait for the initial toplevels, and then for their properties
+	for i := 0; i < 2; i++ {
+		if err := client.roundtrip(); err != nil {
+			client.close(err)
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *thumbnailer) listEvent(opcode uint16, m *wlMessage) {
+	if opcode != 0 { // toplevel
+		return
+	}
+	toplevel := &extToplevel{handle: m.readUint()}
+	t.mu.Lock()
+	t.toplevels[toplevel.handle] = toplevel
+	t.mu.Unlock()
+
+	t.client.handle(toplevel.handle, func(opcode uint16, m *wlMessage) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		switch opcode {
+		case 0: // closed
+			delete(t.toplevels, toplevel.handle)
+			// destroy the handle
+			if err := t.client.request(toplevel.handle, 0, nil); err != nil {
+				log.Warn(err)
+			}
+			t.client.forget(toplevel.handle)
+		case 2: // title
+			toplevel.title = m.readString()
+		case 3: // app_id
+			toplevel.appID = m.readString()
+		case 4: // identifier
+			toplevel.identifier = m.readString()
+		}
+	})
+}
+
+// identifiers of all cons, with a single backend request per popup; w/o them, windows get matched by app_id and title
+func toplevelIdentifiers() map[int64]string {
+	b, ok := backend.(toplevelIdentifier)
+	if !ok {
+		return nil
+	}
+	identifiers, err := b.ToplevelIdentifiers()
+	if err != nil {
+		log.Debugf("Matching windows by app_id and title: %s", err)
+	}
+	return identifiers
+}
+
+func (t *thumbnailer) findToplevel(instance task, identifier string) (uint32, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, toplevel := range t.toplevels {
+		if identifier != "" && toplevel.identifier == identifier {
+			return toplevel.handle, nil
+		}
+		if identifier == "" && toplevel.appID == instance.ID && toplevel.title == instance.Name {
+			return toplevel.handle, nil
+		}
+	}
+	return 0, fmt.Errorf("no toplevel found for con %v", instance.conID)
+}
+
+// a captured window, scaled down, in 8-bit RGB
+type thumbnail struct {
+	pixels []byte
+	width  int
+	height int
+}
+
+// captures the window into a shm buffer, and scales it down to the width; may take a while
+func (t *thumbnailer) capture(instance task, identifier string, width int) (*thumbnail, error) {
+	t.captureMu.Lock()
+	defer t.captureMu.Unlock()
+
+	handle, err := t.findToplevel(instance, identifier)
+	if err != nil {
+		return nil, err
+	}
+	c := t.client
+
+	source := c.newID(nil)
+	if err := c.request(t.sourceManager, 0, wlArgs{}.putUint(source).putUint(handle)); err != nil {
+		return nil, err
+	}
+	defer c.request(source, 0, nil)
+
+	// the session tells the buffer size, and the formats it accepts
+	var bufWidth, bufHeight int
+	var formats []uint32
+	constraints := make(chan bool, 1)
+	session := c.newID(func(opcode uint16, m *wlMessage) {
+		switch opcode {
+		case 0: // buffer_size
+			bufWidth, bufHeight = int(m.readUint()), int(m.readUint())
+		case 1: // shm_format
+			formats = append(formats, m.readUint())
+		case 4: // done
+			notify(constraints, true)
+		case 5: // stopped
+			notify(constraints, false)
+		}
+	})
+	if err := c.request(t.copyManager, 0, wlArgs{}.putUint(session).putUint(source).putUint(0)); err != nil {
+		return nil, err
+	}
+	defer c.request(session, 1, nil)
+
+	if ok, err := c.wait(constraints); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errors.New("capture session stopped")
+	}
+
+	format := uint32(0)
+	found := false
+	for _, f := range formats {
+		if f == wlShmFormatXRGB8888 || f == wlShmFormatARGB8888 {
+			format, found = f, true
+			break
+		}
+	}
+	if !found || bufWidth == 0 || bufHeight == 0 {
+		return nil, errors.New("no usable shm buffer format")
+	}
+
+	stride := bufWidth * 4
+	size := stride * bufHeight
+	file, err := os.CreateTemp(os.Getenv("XDG_RUNTIME_DIR"), "nwg-dock-shm-*")
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Remove(file.Name())
+	defer file.Close()
+	if err := file.Truncate(int64(size)); err != nil {
+		return nil, err
+	}
+
+	pool := c.newID(nil)
+	if err := c.requestFd(t.shm, 0, wlArgs{}.putUint(pool).putInt(int32(size)), int(file.Fd())); err != nil {
+		return nil, err
+	}
+	defer c.request(pool, 1, nil)
+
+	buffer := c.newID(nil)
+	args := wlArgs{}.putUint(buffer).putInt(0).putInt(int32(bufWidth)).putInt(int32(bufHeight)).putInt(int32(stride)).putUint(format)
+	if err := c.request(pool, 0, args); err != nil {
+		return nil, err
+	}
+	defer c.request(buffer, 0, nil)
+
+	result := make(chan bool, 1)
+	frame := c.newID(func(opcode uint16, m *wlMessage) {
+		switch opcode {
+		case 3: // ready
+			notify(result, true)
+		case 4: // failed
+			notify(result, false)
+		}
+	})
+	if err := c.request(session, 0, wlArgs{}.putUint(frame)); err != nil {
+		return nil, err
+	}
+	defer c.request(frame, 0, nil)
+
+	if err := c.request(frame, 1, wlArgs{}.putUint(buffer)); err != nil {
+		return nil, err
+	}
+	if err := c.request(frame, 2, wlArgs{}.putInt(0).putInt(0).putInt(int32(bufWidth)).putInt(int32(bufHeight))); err != nil {
+		return nil, err
+	}
+	if err := c.request(frame, 3, nil); err != nil {
+		return nil, err
+	}
+
+	if ok, err := c.wait(result); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errors.New("capture failed")
+	}
+
+	data := make([]byte, size)
+	if _, err := file.ReadAt(data, 0); err != nil {
+		return nil, err
+	}
+	return scaleDown(data, bufWidth, bufHeight, stride, width), nil
+}
+
+// non-blocking send, as handlers may fire again after we stopped listening
+func notify(ch chan bool, value bool) {
+	select {
+	case ch <- value:
+	default:
+	}
+}
+
+// waits for a value from a handler; must not be called from a handler
+func (c *wlClient) wait(ch chan bool) (bool, error) {
+	select {
+	case value := <-ch:
+		return value, nil
+	case <-c.closed:
+		return false, c.err
+	case <-time.After(2 * time.Second):
+		return false, errors.New("wayland capture timed out")
+	}
+}
+
+// nearest neighbour scaling of the little-endian xRGB buffer into RGB
+func scaleDown(data []byte, width, height, stride, maxWidth int) *thumbnail {
+	w := min(width, maxWidth)
+	h := max(height*w/width, 1)
+	thumb := &thumbnail{pixels: make([]byte, w*h*3), width: w, height: h}
+	for y := 0; y < h; y++ {
+		row := data[y*height/h*stride:]
+		for x := 0; x < w; x++ {
+			src := row[x*width/w*4:]
+			dst := thumb.pixels[(y*w+x)*3:]
+			dst[0], dst[1], dst[2] = src[2], src[1], src[0]
+		}
+	}
+	return thumb
+}
+
+func (th *thumbnail) pixbuf() (*gdk.Pixbuf, error) {
+	pixbuf, err := gdk.PixbufNew(gdk.COLORSPACE_RGB, false, 8, th.width, th.height)
+	if err != nil {
+		return nil, err
+	}
+	pixels := pixbuf.GetPixels()
+	rowstride := pixbuf.GetRowstride()
+	for y := 0; y < th.height; y++ {
+		copy(pixels[y*rowstride:], th.pixels[y*th.width*3:(y+1)*th.width*3])
+	}
+	return pixbuf, nil
+}
+
+/*
+Returns a popup with thumbnails of task instances in a grid, or false if thumbnails are unavailable. It's a menu
+rather than a gtk.Popover, as the latter would get clipped to the dock window. Thumbnails come in asynchronously;
+until then instances show the application icon.
+*/
+func thumbnailMenu(taskID string, instances []task) (*gtk.Menu, bool) {
+	t, err := getThumbnailer()
+	if err != nil {
+		return nil, false
+	}
+
+	menu, _ := gtk.MenuNew()
+	ctx, _ := menu.GetStyleContext()
+	ctx.AddClass("thumbnails")
+
+	iconName, _ := groupIconName(taskID)
+	columns := min(len(instances), 3)
+	images := make([]*gtk.Image, len(instances))
+	for i, instance := range instances {
+		menuItem, _ := gtk.MenuItemNew()
+		vbox, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 6)
+		image, _ := gtk.ImageNewFromIconName(iconName, gtk.ICON_SIZE_DIALOG)
+		image.SetSizeRequest(thumbnailWidth, thumbnailWidth*9/16)
+		vbox.PackStart(image, true, true, 0)
+		images[i] = image
+		label, _ := gtk.LabelNew(instanceLabel(instance))
+		vbox.PackStart(label, false, false, 0)
+		menuItem.Add(vbox)
+		if instance.Focused {
+			ctx, _ := menuItem.GetStyleContext()
+			ctx.AddClass("focused")
+		}
+		if instance.Minimized {
+			ctx, _ := menuItem.GetStyleContext()
+			ctx.AddClass("minimized")
+		}
+		menuItem.Connect("activate", func() {
+			focusTask(instance)
+		})
+		col, row := uint(i%columns), uint(i/columns)
+		menu.Attach(menuItem, col, col+1, row, row+1)
+	}
+	menu.ShowAll()
+
+	go func() {
+		identifiers := toplevelIdentifiers()
+		for i, instance := range instances {
+			thumb, err := t.capture(instance, identifiers[instance.conID], thumbnailWidth)
+			if err != nil {
+				log.Debugf("No thumbnail of con %v: %s", instance.conID, err)
+				continue
+			}
+			image := images[i]
+			glib.TimeoutAdd(0, func() bool {
+				if pixbuf, err := thumb.pixbuf(); err == nil {
+					image.SetFromPixbuf(pixbuf)
+				}
+				return false
+			})
+		}
+	}()
+
+	return menu, true
+}
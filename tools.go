@@ -10,271 +10,126 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"sort"
+	"slices"
 	"strings"
-	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/gotk3/gotk3/gdk"
 	"github.com/gotk3/gotk3/glib"
 	"github.com/gotk3/gotk3/gtk"
-	"github.com/joshuarubin/go-sway"
 )
 
-var descendants []sway.Node
-
 type task struct {
-	conID int64
-	ID    string // will be created out of app_id or window class
-	Name  string
-	PID   uint32
-	WsNum int64
+	conID     int64
+	ID        string // will be created out of app_id or window class
+	Name      string
+	PID       uint32
+	WsNum     int64
+	WsName    string
+	Minimized bool
+	Maximized bool
+	Urgent    bool
+	Focused   bool
+	Output    string // name of the output the task's workspace belongs to
+}
+
+// returns tasks to be shown in the dock, depending on the mode; pinned tasks are not limited to the current workspace
+func visibleTasks(tasks []task) []task {
+	if !*outputTasks && !*currentWsTasks {
+		return tasks
+	}
+	var visible []task
+	wsName := taskList.workspace()
+	for _, t := range tasks {
+		// minimized tasks (e.g. in the sway scratchpad) belong to no output nor workspace, so we always show them
+		if t.Minimized {
+			visible = append(visible, t)
+			continue
+		}
+		if *outputTasks && t.Output != *targetOutput {
+			continue
+		}
+		if *currentWsTasks && t.WsName != wsName && !inPinned(groupKey(t)) {
+			continue
+		}
+		visible = append(visible, t)
+	}
+	return visible
 }
 
-func taskInstances(ID string, tasks []task) []task {
+// returns tasks of the group
+func taskInstances(key string, tasks []task) []task {
 	var found []task
 	for _, t := range tasks {
-		if strings.Contains(strings.ToUpper(t.ID), strings.ToUpper(ID)) {
+		if sameGroup(groupKey(t), key) {
 			found = append(found, t)
 		}
 	}
 	return found
 }
 
+/*
+TaskChange describes a window event received from the backend. Change holds the event type, e.g. "new", "close"
+or "title", and Task the state of the window after the event. Backends unable to tell what exactly changed leave
+Task nil, and the task list gets re-listed.
+*/
 type TaskChange struct {
-	Change sway.WindowEventChange
+	Change string
 	Task   *task
 }
 
-type swayEventHandler struct {
-	taskUpdateChannel      chan TaskChange
-	workspaceUpdateChannel chan int64
-}
-
-func (t swayEventHandler) Workspace(ctx context.Context, event sway.WorkspaceEvent) {
-	if event.Change == "focus" {
-		// TODO: sway.WorkspaceEvent.Current should contain a Workspace, but contains Node,
-		// this may be an error of the used library ...
-		t.workspaceUpdateChannel <- 0
-	}
-}
-func (t swayEventHandler) Mode(ctx context.Context, event sway.ModeEvent)                       {}
-func (t swayEventHandler) BarConfigUpdate(ctx context.Context, event sway.BarConfigUpdateEvent) {}
-func (t swayEventHandler) Binding(ctx context.Context, event sway.BindingEvent)                 {}
-func (t swayEventHandler) Shutdown(ctx context.Context, event sway.ShutdownEvent)               {}
-func (t swayEventHandler) Tick(ctx context.Context, event sway.TickEvent)                       {}
-func (t swayEventHandler) BarStateUpdate(ctx context.Context, event sway.BarStateUpdateEvent)   {}
-func (t swayEventHandler) BarStatusUpdate(ctx context.Context, event sway.BarStateUpdateEvent)  {}
-func (t swayEventHandler) Input(ctx context.Context, event sway.InputEvent)                     {}
-func (t swayEventHandler) Window(ctx context.Context, window sway.WindowEvent) {
-	if window.Change == "new" || window.Change == "close" {
-		t.taskUpdateChannel <- TaskChange{
-			Change: window.Change,
-			// TODO: gather enough details form sway.WindowEvent to create the task
-			// structure and pass it on for smarter modifying the task array
-			Task: nil,
-		}
-	}
-}
-
-// TODO: The channel should *not* return a []task, but rather a TaskChange event which should
-// be used to modify the list in the frontend ...
-func getTaskChangesChannel(ctx context.Context) (chan []task, error) {
-	taskArrayChannel := make(chan []task, 1)
-	eventHandler := swayEventHandler{
-		taskUpdateChannel: make(chan TaskChange, 1),
+// applies TaskChange events from the backend to taskList, and passes resulting diffs on; the source of taskEvents
+func getTaskChangesChannel(ctx context.Context) (<-chan taskDiff, error) {
+	taskDiffChannel := make(chan taskDiff, 1)
+	taskUpdateChannel, err := backend.TaskEvents(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	go func() {
-		// Blocks execution until we cancel the context
-		if err := sway.Subscribe(ctx, eventHandler, sway.EventTypeWindow); err != nil {
-			log.Fatal("Unable to subscribe to sway event:", err)
-		}
-	}()
-
-	// Pretty hacky, but is simply used to convert a TaskChange to a task struct
 	go func() {
 		for {
-			<-eventHandler.taskUpdateChannel
-			tasks, err := listTasks()
-			if err != nil {
-				log.Errorf("Unable to process tasks from sway: %s", err.Error())
-				return
+			change := <-taskUpdateChannel
+			if change.Task == nil {
+				if _, err := listTasks(); err != nil {
+					// the backend keeps reconnecting, and resends the change when back
+					log.Errorf("Unable to process tasks: %s", err.Error())
+					continue
+				}
+				taskDiffChannel <- taskDiff{Op: tasksReset}
+				continue
 			}
 
-			taskArrayChannel <- tasks
+			for _, diff := range taskList.apply(change) {
+				taskDiffChannel <- diff
+			}
 		}
 	}()
 
-	return taskArrayChannel, nil
+	return taskDiffChannel, nil
 }
 
-func getWorkspaceChangesChannel(ctx context.Context) chan int64 {
-	workspaceUpdateChannel := make(chan int64, 1)
-	eventHandler := swayEventHandler{
-		workspaceUpdateChannel: make(chan int64, 1),
-	}
-
-	go func() {
-		// Blocks execution until we cancel the context
-		if err := sway.Subscribe(ctx, eventHandler, sway.EventTypeWorkspace); err != nil {
-			log.Fatal("Unable to subscribe to sway event:", err)
-		}
-	}()
-
-	go func() {
-		ipc, _ := sway.New(ctx)
-
-		for {
-			<-eventHandler.workspaceUpdateChannel
-			workspaces, _ := ipc.GetWorkspaces(ctx)
-
-			for _, workspace := range workspaces {
-				if workspace.Focused {
-					workspaceUpdateChannel <- workspace.Num
-					break
-				}
-			}
-		}
-	}()
-
-	return workspaceUpdateChannel
+// the source of workspaceEvents, as the backend is only known at runtime
+func getWorkspaceChangesChannel(ctx context.Context) (<-chan string, error) {
+	return backend.WorkspaceEvents(ctx)
 }
 
-// list sway tree, return tasks sorted by workspace numbers
+// list tasks from the backend, return them sorted by workspace numbers; taskList gets reset with them
 func listTasks() ([]task, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
-
-	client, err := sway.New(ctx)
+	tasks, wsName, err := backend.ListTasks()
 	if err != nil {
 		return nil, err
 	}
-
-	tree, err := client.GetTree(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	workspaces, _ := client.GetWorkspaces(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	// In order not to add a separate function, let's set the global currentWsNum variable we need here
-	for _, ws := range workspaces {
-		if ws.Focused {
-			currentWsNum = ws.Num
-			break
-		}
-	}
-
-	// all nodes in the tree
-	nodes := tree.Nodes
-
-	// find outputs in all nodes
-	var outputs []*sway.Node
-	for _, n := range nodes {
-		if n.Type == "output" && !strings.HasPrefix(n.Name, "__") {
-			outputs = append(outputs, n)
-		}
-	}
-
-	// find workspaces in outputs
-	var workspaceNodes []*sway.Node
-	for _, o := range outputs {
-		nodes = o.Nodes
-		for _, n := range nodes {
-			if n.Type == "workspace" {
-				workspaceNodes = append(workspaceNodes, n)
-			}
-		}
-	}
-
-	var tasks []task
-	// find cons in workspaces recursively
-	for _, w := range workspaceNodes {
-		wsNum := workspaceNum(workspaces, w.Name)
-		descendants = nil
-		for _, con := range w.Nodes {
-			findDescendants(*con)
-		}
-
-		// create tasks from cons which represent tasks
-		for _, con := range descendants {
-			t, err := createTask(con, wsNum)
-			if err == nil {
-				tasks = append(tasks, *t)
-			} else {
-				log.Warn(err)
-			}
-		}
-
-		fNodes := w.FloatingNodes
-		for _, con := range fNodes {
-			t, err := createTask(*con, wsNum)
-			if err == nil {
-				tasks = append(tasks, *t)
-			} else {
-				log.Warn(err)
-			}
-		}
-	}
-	sort.Slice(tasks, func(i int, j int) bool {
-		return tasks[i].WsNum < tasks[j].WsNum
-	})
+	taskList.reset(tasks, wsName)
 	return tasks, nil
 }
 
-func findDescendants(con sway.Node) {
-	if len(con.Nodes) > 0 {
-		for _, node := range con.Nodes {
-			findDescendants(*node)
-		}
-	} else {
-		descendants = append(descendants, con)
-	}
-}
-
-func createTask(con sway.Node, wsNum int64) (*task, error) {
-	t := &task{}
-	t.conID = con.ID
-	if con.AppID != nil {
-		t.ID = *con.AppID
-	} else if con.WindowProperties != nil {
-		wp := *con.WindowProperties
-		t.ID = wp.Class
-	} else {
-		return nil, errors.New("damaged Node data received, task skipped")
-	}
-	t.Name = con.Name
-	if con.PID != nil {
-		t.PID = *con.PID
-	} else {
-		return nil, errors.New("damaged Node data received, task skipped")
-	}
-
-	t.WsNum = wsNum
-
-	return t, nil
-}
-
-func workspaceNum(workspaces []sway.Workspace, name string) int64 {
-	for _, ws := range workspaces {
-		if ws.Name == name {
-			return ws.Num
-		}
-	}
-	return 0
-}
-
 func pinnedButton(ID string) *gtk.Box {
 	box, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
 	button, _ := gtk.ButtonNew()
 	box.PackStart(button, false, false, 0)
 
-	image, err := createImage(ID, imgSizeScaled)
+	image, err := groupImage(ID, imgSizeScaled)
 	if err != nil {
 		pixbuf, err := gdk.PixbufNewFromFileAtSize(filepath.Join(dataHome, "nwg-dock/images/icon-missing.svg"),
 			imgSizeScaled, imgSizeScaled)
@@ -290,7 +145,7 @@ func pinnedButton(ID string) *gtk.Box {
 		button.SetImagePosition(gtk.POS_TOP)
 		button.SetAlwaysShowImage(true)
 	}
-	button.SetTooltipText(getName(ID))
+	button.SetTooltipText(groupName(ID))
 	pixbuf, _ := gdk.PixbufNewFromFileAtSize(filepath.Join(dataHome, "nwg-dock/images/task-empty.svg"),
 		imgSizeScaled, imgSizeScaled/8)
 	img, _ := gtk.ImageNewFromPixbuf(pixbuf)
@@ -314,6 +169,7 @@ func pinnedButton(ID string) *gtk.Box {
 	})
 
 	button.Connect("enter-notify-event", cancelClose)
+	setupDragAndDrop(button, ID)
 	return box
 }
 
@@ -375,12 +231,82 @@ func cancelClose() {
 	}
 }
 
+type taskButtonEntry struct {
+	box       *gtk.Box
+	instances []task
+}
+
+// task buttons packed by buildMainBox, by the ID they've been created for
+var taskButtons map[string]*taskButtonEntry
+
+// rebuilds the task buttons the con belongs (or used to belong) to, w/o rebuilding the whole mainBox
+func refreshTaskButtons(tasks []task, conID int64) {
+	tasks = visibleTasks(tasks)
+	for id, entry := range taskButtons {
+		instances := taskInstances(id, tasks)
+		if len(instances) == 0 || !(hasCon(instances, conID) || hasCon(entry.instances, conID)) {
+			continue
+		}
+		box := taskButton(instances[0], instances)
+		replaceButton(entry.box, box)
+		taskButtons[id] = &taskButtonEntry{box: box, instances: instances}
+	}
+}
+
+// packs the new button in place of the old one
+func replaceButton(old, new *gtk.Box) {
+	position, err := mainBox.ChildGetProperty(old, "position", glib.TYPE_INT)
+	old.Destroy()
+	mainBox.PackStart(new, false, false, 0)
+	if err == nil {
+		mainBox.ReorderChild(new, position.(int))
+	}
+	new.ShowAll()
+}
+
+func focusedInstance(instances []task) *task {
+	for i := range instances {
+		if instances[i].Focused {
+			return &instances[i]
+		}
+	}
+	return nil
+}
+
+func urgentInstance(instances []task) *task {
+	for i := range instances {
+		if instances[i].Urgent {
+			return &instances[i]
+		}
+	}
+	return nil
+}
+
+func allMinimized(instances []task) bool {
+	for _, t := range instances {
+		if !t.Minimized {
+			return false
+		}
+	}
+	return len(instances) > 0
+}
+
+func hasCon(tasks []task, conID int64) bool {
+	for _, t := range tasks {
+		if t.conID == conID {
+			return true
+		}
+	}
+	return false
+}
+
 func taskButton(t task, instances []task) *gtk.Box {
 	box, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
 	button, _ := gtk.ButtonNew()
 	box.PackStart(button, false, false, 0)
 
-	image, err := createImage(t.ID, imgSizeScaled)
+	key := groupKey(t)
+	image, err := groupImage(key, imgSizeScaled)
 	if err != nil {
 		pixbuf, err := gdk.PixbufNewFromFileAtSize(filepath.Join(dataHome, "nwg-dock/images/icon-missing.svg"),
 			imgSizeScaled, imgSizeScaled)
@@ -396,19 +322,36 @@ func taskButton(t task, instances []task) *gtk.Box {
 		button.SetImagePosition(gtk.POS_TOP)
 		button.SetAlwaysShowImage(true)
 	}
-	button.SetTooltipText(getName(t.ID))
-	var img *gtk.Image
-	if len(instances) < 2 {
-		pixbuf, _ := gdk.PixbufNewFromFileAtSize(filepath.Join(dataHome, "nwg-dock/images/task-single.svg"),
-			imgSizeScaled, imgSizeScaled/8)
-		img, _ = gtk.ImageNewFromPixbuf(pixbuf)
-	} else {
-		pixbuf, _ := gdk.PixbufNewFromFileAtSize(filepath.Join(dataHome, "nwg-dock/images/task-multiple.svg"),
-			imgSizeScaled, imgSizeScaled/8)
-		img, _ = gtk.ImageNewFromPixbuf(pixbuf)
+	button.SetTooltipText(groupName(key))
+	// the indicator of the focused application gets the "-active" variant
+	indicator := "task-single"
+	if len(instances) > 1 {
+		indicator = "task-multiple"
 	}
+	if focusedInstance(instances) != nil {
+		ctx, _ := button.GetStyleContext()
+		ctx.AddClass("active")
+		indicator += "-active"
+	}
+	pixbuf, _ := gdk.PixbufNewFromFileAtSize(filepath.Join(dataHome, fmt.Sprintf("nwg-dock/images/%s.svg", indicator)),
+		imgSizeScaled, imgSizeScaled/8)
+	img, _ := gtk.ImageNewFromPixbuf(pixbuf)
 	box.PackStart(img, false, false, 0)
 
+	if allMinimized(instances) {
+		ctx, _ := button.GetStyleContext()
+		ctx.AddClass("minimized")
+	}
+
+	urgent := urgentInstance(instances)
+	if urgent != nil {
+		ctx, _ := button.GetStyleContext()
+		ctx.AddClass("urgent")
+		if *attention != "none" {
+			ctx.AddClass(fmt.Sprintf("attention-%s", *attention))
+		}
+	}
+
 	button.Connect("enter-notify-event", cancelClose)
 
 	if len(instances) == 1 {
@@ -420,10 +363,10 @@ func taskButton(t task, instances []task) *gtk.Box {
 			   A gotk3 bug or WTF? */
 			if btnEvent.Type() == gdk.EVENT_BUTTON_RELEASE || btnEvent.Type() == gdk.EVENT_TOUCH_END {
 				if btnEvent.Button() == 1 || btnEvent.Type() == gdk.EVENT_TOUCH_END {
-					focusCon(t.conID)
+					focusTask(instances[0])
 					return true
 				} else if btnEvent.Button() == 3 {
-					contextMenu := taskMenuContext(t.ID, instances)
+					contextMenu := taskMenuContext(key, instances)
 					contextMenu.PopupAtWidget(button, widgetAnchor, menuAnchor, nil)
 					return true
 				}
@@ -434,11 +377,22 @@ func taskButton(t task, instances []task) *gtk.Box {
 		button.Connect("button-release-event", func(btn *gtk.Button, e *gdk.Event) bool {
 			btnEvent := gdk.EventButtonNewFromEvent(e)
 			if btnEvent.Button() == 1 {
-				menu := taskMenu(t.ID, instances)
+				// the instance asking for attention goes first
+				if urgent != nil {
+					focusTask(*urgent)
+					return true
+				}
+				if !*noThumbnails {
+					if menu, ok := thumbnailMenu(key, instances); ok {
+						menu.PopupAtWidget(button, widgetAnchor, menuAnchor, nil)
+						return true
+					}
+				}
+				menu := taskMenu(key, instances)
 				menu.PopupAtWidget(button, widgetAnchor, menuAnchor, nil)
 				return true
 			} else if btnEvent.Button() == 3 {
-				contextMenu := taskMenuContext(t.ID, instances)
+				contextMenu := taskMenuContext(key, instances)
 				contextMenu.PopupAtWidget(button, widgetAnchor, menuAnchor, nil)
 				return true
 			}
@@ -446,30 +400,49 @@ func taskButton(t task, instances []task) *gtk.Box {
 		})
 	}
 
+	setupDragAndDrop(button, key)
 	return box
 }
 
+// title cut to 20 bytes, followed by the workspace name if the backend knows workspaces
+func instanceLabel(instance task) string {
+	title := instance.Name
+	if len(title) > 20 {
+		title = title[:20]
+	}
+	if instance.Minimized {
+		return fmt.Sprintf("%s (minimized)", title)
+	}
+	if backend.Supports(featureWorkspaces) {
+		return fmt.Sprintf("%s (%s)", title, instance.WsName)
+	}
+	return title
+}
+
 func taskMenu(taskID string, instances []task) gtk.Menu {
 	menu, _ := gtk.MenuNew()
 
-	iconName, _ := getIcon(taskID)
+	iconName, _ := groupIconName(taskID)
 
 	for _, instance := range instances {
 		menuItem, _ := gtk.MenuItemNew()
 		hbox, _ := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 6)
 		image, _ := gtk.ImageNewFromIconName(iconName, gtk.ICON_SIZE_MENU)
 		hbox.PackStart(image, false, false, 0)
-		title := instance.Name
-		if len(title) > 20 {
-			title = title[:20]
-		}
-		label, _ := gtk.LabelNew(fmt.Sprintf("%s (%v)", title, instance.WsNum))
+		label, _ := gtk.LabelNew(instanceLabel(instance))
 		hbox.PackStart(label, false, false, 0)
 		menuItem.Add(hbox)
+		if instance.Focused {
+			ctx, _ := menuItem.GetStyleContext()
+			ctx.AddClass("focused")
+		}
+		if instance.Minimized {
+			ctx, _ := menuItem.GetStyleContext()
+			ctx.AddClass("minimized")
+		}
 		menu.Append(menuItem)
-		conID := instance.conID
 		menuItem.Connect("activate", func() {
-			focusCon(conID)
+			focusTask(instance)
 		})
 
 	}
@@ -480,7 +453,7 @@ func taskMenu(taskID string, instances []task) gtk.Menu {
 func taskMenuContext(taskID string, instances []task) gtk.Menu {
 	menu, _ := gtk.MenuNew()
 
-	iconName, err := getIcon(taskID)
+	iconName, err := groupIconName(taskID)
 	if err != nil {
 		log.Warnf("%s %s", err, taskID)
 	}
@@ -489,13 +462,17 @@ func taskMenuContext(taskID string, instances []task) gtk.Menu {
 		hbox, _ := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 6)
 		image, _ := gtk.ImageNewFromIconName(iconName, gtk.ICON_SIZE_MENU)
 		hbox.PackStart(image, false, false, 0)
-		title := instance.Name
-		if len(title) > 20 {
-			title = title[:20]
-		}
-		label, _ := gtk.LabelNew(fmt.Sprintf("%s (%v)", title, instance.WsNum))
+		label, _ := gtk.LabelNew(instanceLabel(instance))
 		hbox.PackStart(label, false, false, 0)
 		menuItem.Add(hbox)
+		if instance.Focused {
+			ctx, _ := menuItem.GetStyleContext()
+			ctx.AddClass("focused")
+		}
+		if instance.Minimized {
+			ctx, _ := menuItem.GetStyleContext()
+			ctx.AddClass("minimized")
+		}
 		menu.Append(menuItem)
 
 		submenu, _ := gtk.MenuNew()
@@ -506,14 +483,41 @@ func taskMenuContext(taskID string, instances []task) gtk.Menu {
 		subitem.Connect("activate", func() {
 			killCon(conID)
 		})
-		for i := 1; i < int(*numWS)+1; i++ {
-			subitem, _ := gtk.MenuItemNewWithLabel(fmt.Sprintf("To WS %v", i))
-			target := i
+		if backend.Supports(featureMinimize) {
+			subitem, _ := gtk.MenuItemNewWithLabel("Minimize")
+			if backend.Supports(featureScratchpad) {
+				subitem.SetLabel("Minimize to scratchpad")
+			}
+			if instance.Minimized {
+				subitem.SetLabel("Restore")
+			}
+			minimized := !instance.Minimized
 			subitem.Connect("activate", func() {
-				con2WS(conID, target)
+				setMinimized(conID, minimized)
 			})
 			submenu.Append(subitem)
 		}
+		if backend.Supports(featureMaximize) {
+			subitem, _ := gtk.MenuItemNewWithLabel("Maximize")
+			if instance.Maximized {
+				subitem.SetLabel("Unmaximize")
+			}
+			maximized := !instance.Maximized
+			subitem.Connect("activate", func() {
+				setMaximized(conID, maximized)
+			})
+			submenu.Append(subitem)
+		}
+		if backend.Supports(featureWorkspaces) {
+			for _, num := range moveTargets() {
+				subitem, _ := gtk.MenuItemNewWithLabel(fmt.Sprintf("To WS %v", num))
+				target := int(num)
+				subitem.Connect("activate", func() {
+					con2WS(conID, target)
+				})
+				submenu.Append(subitem)
+			}
+		}
 
 		menuItem.SetSubmenu(submenu)
 	}
@@ -547,17 +551,12 @@ func taskMenuContext(taskID string, instances []task) gtk.Menu {
 }
 
 func inPinned(taskID string) bool {
-	for _, id := range pinned {
-		if strings.TrimSpace(taskID) == strings.TrimSpace(id) {
-			return true
-		}
-	}
-	return false
+	return pinFor(taskID) != nil
 }
 
 func inTasks(tasks []task, pinID string) bool {
 	for _, task := range tasks {
-		if strings.TrimSpace(task.ID) == strings.TrimSpace(pinID) {
+		if sameGroup(groupKey(task), pinID) {
 			return true
 		}
 	}
@@ -902,52 +901,49 @@ func loadTextFile(path string) ([]string, error) {
 }
 
 func pinTask(itemID string) {
-	for _, item := range pinned {
-		if item == itemID {
-			println(item, "already pinned")
-			return
+	savePinned(func(pins []*pin) []*pin {
+		if slices.ContainsFunc(pins, func(p *pin) bool { return sameGroup(p.ID, itemID) }) {
+			println(itemID, "already pinned")
+			return pins
 		}
-	}
-	pinned = append(pinned, itemID)
-	savePinned()
-	refreshMainBoxChannel <- struct{}{}
+		return append(pins, &pin{ID: itemID})
+	})
 }
 
 func unpinTask(itemID string) {
-	pinned = remove(pinned, itemID)
-	savePinned()
-	refreshMainBoxChannel <- struct{}{}
-}
-
-func remove(s []string, r string) []string {
-	for i, v := range s {
-		if v == r {
-			return append(s[:i], s[i+1:]...)
-		}
-	}
-	return s
+	savePinned(func(pins []*pin) []*pin {
+		return slices.DeleteFunc(pins, func(p *pin) bool {
+			return sameGroup(p.ID, itemID)
+		})
+	})
 }
 
-func savePinned() {
-	f, err := os.OpenFile(pinnedFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+// applies the change to the pinned file, and refreshes the dock
+func savePinned(change func(pins []*pin) []*pin) {
+	pins, err := updatePins(pinnedFile, change)
 	if err != nil {
-		log.Fatal(err)
+		log.Errorf("Error saving pinned: %s", err)
+		return
 	}
+	pinned = pins
+	refreshMainBoxChannel <- struct{}{}
+}
 
-	defer f.Close()
-
-	for _, line := range pinned {
-		if line != "" {
-			_, err := f.WriteString(line + "\n")
-
-			if err != nil {
-				log.Errorf("Error saving pinned", err)
-			}
+func launch(ID string) {
+	p := pinFor(ID)
+	if p != nil && p.Command != "" {
+		cmd := exec.Command("sh", "-c", p.Command)
+		p.setup(cmd)
+		log.Infof("command: '%s'", p.Command)
+		if err := cmd.Start(); err != nil {
+			log.Error("Unable to launch command!", err.Error())
+		}
+		if *autohide {
+			win.Hide()
 		}
+		return
 	}
-}
 
-func launch(ID string) {
 	command, err := getExec(ID)
 	if err != nil {
 		log.Errorf("%s", err)
@@ -991,6 +987,9 @@ func launch(ID string) {
 		cmd.Env = os.Environ()
 		cmd.Env = append(cmd.Env, envVars...)
 	}
+	if p != nil {
+		p.setup(cmd)
+	}
 
 	msg := fmt.Sprintf("env vars: %s; command: '%s'; args: %s\n", envVars, elements[cmdIdx], args)
 	log.Info(msg)
@@ -1005,15 +1004,7 @@ func launch(ID string) {
 }
 
 func focusCon(conID int64) {
-	cmd := fmt.Sprintf("[con_id=%v] focus", conID)
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
-
-	client, err := sway.New(ctx)
-	if err != nil {
-		log.Panic(err)
-	}
-	if _, err = client.RunCommand(ctx, cmd); err != nil {
+	if err := backend.FocusCon(conID); err != nil {
 		log.Errorf("Unable to focus to con %v: %s", conID, err.Error())
 	}
 
@@ -1025,17 +1016,17 @@ func focusCon(conID int64) {
 	}
 }
 
-func focusWorkspace(num int64) {
-	cmd := fmt.Sprintf("workspace number %v", num)
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
-
-	client, err := sway.New(ctx)
-	if err != nil {
-		log.Panic(err)
+// focuses the task; a minimized one gets restored first, e.g. shown from the sway scratchpad
+func focusTask(t task) {
+	if t.Minimized {
+		setMinimized(t.conID, false)
 	}
-	if _, err = client.RunCommand(ctx, cmd); err != nil {
-		log.Errorf("Unable to focus to workspace %v: %s", num, err.Error())
+	focusCon(t.conID)
+}
+
+func focusWorkspace(name string) {
+	if err := backend.FocusWorkspace(name); err != nil {
+		log.Errorf("Unable to focus to workspace %s: %s", name, err.Error())
 	}
 
 	if *autohide {
@@ -1047,15 +1038,7 @@ func focusWorkspace(num int64) {
 }
 
 func killCon(conID int64) {
-	cmd := fmt.Sprintf("[con_id=%v] kill", conID)
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
-
-	client, err := sway.New(ctx)
-	if err != nil {
-		log.Panic(err)
-	}
-	if _, err = client.RunCommand(ctx, cmd); err != nil {
+	if err := backend.KillCon(conID); err != nil {
 		log.Errorf("Unable to kill con %v: %s", conID, err.Error())
 	}
 
@@ -1068,16 +1051,7 @@ func killCon(conID int64) {
 }
 
 func con2WS(conID int64, wsNum int) {
-	cmd := fmt.Sprintf("[con_id=%v] move to workspace number %v", conID, wsNum)
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
-
-	client, err := sway.New(ctx)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	if _, err = client.RunCommand(ctx, cmd); err != nil {
+	if err := backend.MoveConToWorkspace(conID, wsNum); err != nil {
 		log.Errorf("Unable to move to workspace %v: %s", wsNum, err.Error())
 	}
 
@@ -1091,19 +1065,35 @@ func con2WS(conID int64, wsNum int) {
 	}
 }
 
-// Returns map output name -> gdk.Monitor
-func mapOutputs() (map[string]*gdk.Monitor, error) {
-	result := make(map[string]*gdk.Monitor)
+func setMinimized(conID int64, minimized bool) {
+	if err := backend.SetMinimized(conID, minimized); err != nil {
+		log.Errorf("Unable to set con %v minimized=%v: %s", conID, minimized, err.Error())
+	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
+func setMaximized(conID int64, maximized bool) {
+	if err := backend.SetMaximized(conID, maximized); err != nil {
+		log.Errorf("Unable to set con %v maximized=%v: %s", conID, maximized, err.Error())
+	}
+}
 
-	client, err := sway.New(ctx)
-	if err != nil {
-		return nil, err
+// marks the dock box with the "disconnected" style class while the compositor IPC is down
+func setDisconnectedState(box *gtk.Box, disconnected bool) {
+	style, _ := box.GetStyleContext()
+	if disconnected {
+		style.AddClass("disconnected")
+		box.SetTooltipText("Disconnected from the compositor")
+	} else {
+		style.RemoveClass("disconnected")
+		_ = box.SetProperty("has-tooltip", false)
 	}
+}
+
+// Returns map output name -> gdk.Monitor
+func mapOutputs() (map[string]*gdk.Monitor, error) {
+	result := make(map[string]*gdk.Monitor)
 
-	outputs, err := client.GetOutputs(ctx)
+	outputs, err := backend.Outputs()
 	if err != nil {
 		return nil, err
 	}
@@ -1119,7 +1109,7 @@ func mapOutputs() (map[string]*gdk.Monitor, error) {
 		geometry := monitor.GetGeometry()
 		// assign output to monitor on the basis of the same x, y coordinates
 		for _, output := range outputs {
-			if int(output.Rect.X) == geometry.GetX() && int(output.Rect.Y) == geometry.GetY() {
+			if output.X == geometry.GetX() && output.Y == geometry.GetY() {
 				result[output.Name] = monitor
 			}
 		}
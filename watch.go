@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Watches directories with inotify, and calls onChange with paths of files written, moved in or deleted there; on
+the watcher goroutine, not the GTK main loop. We watch directories instead of files, as editors and dotfile
+managers often replace files by renaming new ones over them.
+*/
+func watchDirs(dirs []string, onChange func(path string)) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+
+	// adding the same directory again gives the same watch descriptor
+	watched := make(map[int32]string)
+	for _, dir := range dirs {
+		wd, err := syscall.InotifyAddWatch(fd, dir,
+			syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_DELETE)
+		if err != nil {
+			syscall.Close(fd)
+			return err
+		}
+		watched[int32(wd)] = dir
+	}
+
+	go func() {
+		f := os.NewFile(uintptr(fd), "inotify")
+		defer f.Close()
+
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				log.Errorf("Unable to watch files: %s", err)
+				return
+			}
+
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				nameEnd := nameStart + int(event.Len)
+				offset = nameEnd
+				if event.Len == 0 || nameEnd > n {
+					continue
+				}
+
+				name := string(bytes.TrimRight(buf[nameStart:nameEnd], "\x00"))
+				if dir, ok := watched[event.Wd]; ok {
+					onChange(filepath.Join(dir, name))
+				}
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+A minimal Wayland client, speaking the wire protocol directly. It's just enough to bind some
+globals and exchange messages with them, on a connection of its own, separate from the GTK one.
+Handlers are called from the dispatch goroutine, so they must not wait for a roundtrip.
+*/
+
+const wlDisplayID = 1
+
+type wlHandler func(opcode uint16, m *wlMessage)
+
+type wlGlobal struct {
+	name    uint32
+	iface   string
+	version uint32
+}
+
+type wlClient struct {
+	conn     *net.UnixConn
+	mu       sync.Mutex
+	nextID   uint32
+	handlers map[uint32]wlHandler
+	globals  []wlGlobal
+	registry uint32
+	closed   chan struct{}
+	once     sync.Once
+	err      error
+}
+
+func wlConnect() (*wlClient, error) {
+	display := os.Getenv("WAYLAND_DISPLAY")
+	if display == "" {
+		display = "wayland-0"
+	}
+	path := display
+	if !filepath.IsAbs(path) {
+		if os.Getenv("XDG_RUNTIME_DIR") == "" {
+			return nil, errors.New("$XDG_RUNTIME_DIR is empty")
+		}
+		path = filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), display)
+	}
+
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &wlClient{
+		conn:     conn,
+		nextID:   wlDisplayID + 1,
+		handlers: make(map[uint32]wlHandler),
+		closed:   make(chan struct{}),
+	}
+	c.handle(wlDisplayID, c.displayEvent)
+	c.registry = c.newID(c.registryEvent)
+	if err := c.request(wlDisplayID, 1, wlArgs{}.putUint(c.registry)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.dispatch()
+
+	// wait for the initial burst of wl_registry.global events
+	if err := c.roundtrip(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *wlClient) displayEvent(opcode uint16, m *wlMessage) {
+	switch opcode {
+	case 0: // error
+		objectID, code, message := m.readUint(), m.readUint(), m.readString()
+		log.Errorf("Wayland error on object %v, code %v: %s", objectID, code, message)
+	case 1: // delete_id
+		id := m.readUint()
+		c.mu.Lock()
+		delete(c.handlers, id)
+		c.mu.Unlock()
+	}
+}
+
+func (c *wlClient) registryEvent(opcode uint16, m *wlMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch opcode {
+	case 0: // global
+		g := wlGlobal{name: m.readUint(), iface: m.readString(), version: m.readUint()}
+		c.globals = append(c.globals, g)
+	case 1: // global_remove
+		name := m.readUint()
+		for i, g := range c.globals {
+			if g.name == name {
+				c.globals = append(c.globals[:i], c.globals[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// returns globals implementing the interface
+func (c *wlClient) findGlobals(iface string) []wlGlobal {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var found []wlGlobal
+	for _, g := range c.globals {
+		if g.iface == iface {
+			found = append(found, g)
+		}
+	}
+	return found
+}
+
+// binds the global with the highest version we understand, returns the new object id
+func (c *wlClient) bind(g wlGlobal, maxVersion uint32, handler wlHandler) (uint32, error) {
+	version := g.version
+	if version > maxVersion {
+		version = maxVersion
+	}
+	id := c.newID(handler)
+	err := c.request(c.registry, 0, wlArgs{}.putUint(g.name).putString(g.iface).putUint(version).putUint(id))
+	return id, err
+}
+
+// allocates a client-side object id
+func (c *wlClient) newID(handler wlHandler) uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := c.nextID
+	c.nextID++
+	if handler != nil {
+		c.handlers[id] = handler
+	}
+	return id
+}
+
+// sets the handler for an object, e.g. one created by the server
+func (c *wlClient) handle(id uint32, handler wlHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handlers[id] = handler
+}
+
+// removes the handler of a destroyed server-created object; those get no delete_id
+func (c *wlClient) forget(id uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.handlers, id)
+}
+
+func (c *wlClient) request(id uint32, opcode uint16, args wlArgs) error {
+	msg := wlArgs{}.putUint(id).putUint(uint32(8+len(args))<<16 | uint32(opcode))
+	msg = append(msg, args...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+// sends a request with a file descriptor argument, which travels out of band; fds go after other args
+func (c *wlClient) requestFd(id uint32, opcode uint16, args wlArgs, fd int) error {
+	msg := wlArgs{}.putUint(id).putUint(uint32(8+len(args))<<16 | uint32(opcode))
+	msg = append(msg, args...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, _, err := c.conn.WriteMsgUnix(msg, syscall.UnixRights(fd), nil)
+	return err
+}
+
+// sends wl_display.sync, and waits for the callback; must not be called from a handler
+func (c *wlClient) roundtrip() error {
+	done := make(chan struct{})
+	callback := c.newID(func(opcode uint16, m *wlMessage) {
+		close(done)
+	})
+	if err := c.request(wlDisplayID, 0, wlArgs{}.putUint(callback)); err != nil {
+		return err
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-c.closed:
+		return c.err
+	case <-time.After(time.Second):
+		return errors.New("wayland roundtrip timed out")
+	}
+}
+
+func (c *wlClient) dispatch() {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(c.conn, header); err != nil {
+			c.close(err)
+			return
+		}
+		id := binary.LittleEndian.Uint32(header)
+		size := binary.LittleEndian.Uint32(header[4:]) >> 16
+		opcode := uint16(binary.LittleEndian.Uint32(header[4:]) & 0xffff)
+		if size < 8 {
+			c.close(fmt.Errorf("malformed wayland message, size %v", size))
+			return
+		}
+
+		body := make([]byte, size-8)
+		if _, err := io.ReadFull(c.conn, body); err != nil {
+			c.close(err)
+			return
+		}
+
+		c.mu.Lock()
+		handler := c.handlers[id]
+		c.mu.Unlock()
+		if handler != nil {
+			handler(opcode, &wlMessage{data: body})
+		}
+	}
+}
+
+func (c *wlClient) close(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.closed)
+		_ = c.conn.Close()
+	})
+}
+
+// wlArgs builds request arguments
+type wlArgs []byte
+
+func (a wlArgs) putUint(v uint32) wlArgs {
+	return binary.LittleEndian.AppendUint32(a, v)
+}
+
+func (a wlArgs) putInt(v int32) wlArgs {
+	return a.putUint(uint32(v))
+}
+
+func (a wlArgs) putString(s string) wlArgs {
+	a = a.putUint(uint32(len(s) + 1))
+	a = append(a, s...)
+	a = append(a, 0)
+	return a.pad()
+}
+
+func (a wlArgs) putArray(b []byte) wlArgs {
+	a = a.putUint(uint32(len(b)))
+	a = append(a, b...)
+	return a.pad()
+}
+
+func (a wlArgs) pad() wlArgs {
+	for len(a)%4 != 0 {
+		a = append(a, 0)
+	}
+	return a
+}
+
+// wlMessage reads event arguments
+type wlMessage struct {
+	data []byte
+	off  int
+}
+
+func (m *wlMessage) readUint() uint32 {
+	if m.off+4 > len(m.data) {
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(m.data[m.off:])
+	m.off += 4
+	return v
+}
+
+func (m *wlMessage) readInt() int32 {
+	return int32(m.readUint())
+}
+
+func (m *wlMessage) readString() string {
+	b := m.readArray()
+	if len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return string(b)
+}
+
+func (m *wlMessage) readArray() []byte {
+	size := int(m.readUint())
+	if size == 0 || m.off+size > len(m.data) {
+		return nil
+	}
+	b := m.data[m.off : m.off+size]
+	m.off += (size + 3) &^ 3
+	return b
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWlArgs(t *testing.T) {
+	args := wlArgs{}.putUint(7).putString("wl_shm").putInt(-2).putArray([]byte{1, 2, 3}).putString("")
+	want := []byte{
+		7, 0, 0, 0,
+		7, 0, 0, 0, 'w', 'l', '_', 's', 'h', 'm', 0, 0, // the length counts the NUL, padded to 4 bytes
+		0xfe, 0xff, 0xff, 0xff,
+		3, 0, 0, 0, 1, 2, 3, 0,
+		1, 0, 0, 0, 0, 0, 0, 0,
+	}
+	if !bytes.Equal(args, want) {
+		t.Errorf("args = %v, want %v", []byte(args), want)
+	}
+
+	m := &wlMessage{data: args}
+	if v := m.readUint(); v != 7 {
+		t.Errorf("uint = %v", v)
+	}
+	if s := m.readString(); s != "wl_shm" {
+		t.Errorf("string = %q", s)
+	}
+	if v := m.readInt(); v != -2 {
+		t.Errorf("int = %v", v)
+	}
+	if b := m.readArray(); !bytes.Equal(b, []byte{1, 2, 3}) {
+		t.Errorf("array = %v", b)
+	}
+	if s := m.readString(); s != "" {
+		t.Errorf("empty string = %q", s)
+	}
+}
+
+func TestWlMessageTruncated(t *testing.T) {
+	// a broken message gives zero values, rather than a panic
+	m := &wlMessage{data: []byte{1, 2}}
+	if v := m.readUint(); v != 0 {
+		t.Errorf("uint = %v", v)
+	}
+
+	m = &wlMessage{data: wlArgs{}.putUint(100).putUint(1)}
+	if b := m.readArray(); b != nil {
+		t.Errorf("array longer than the message = %v", b)
+	}
+	if s := (&wlMessage{}).readString(); s != "" {
+		t.Errorf("string = %q", s)
+	}
+}
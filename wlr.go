@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Backend for wlroots-based compositors w/o an IPC of their own (labwc, Wayfire, river...),
+built on the wlr-foreign-toplevel-management protocol. The protocol knows nothing about
+workspaces, so the related features are turned off.
+*/
+
+const (
+	wlrToplevelStateMaximized = 0
+	wlrToplevelStateMinimized = 1
+	wlrToplevelStateActivated = 2
+)
+
+type wlrToplevelState struct {
+	appID     string
+	title     string
+	minimized bool
+	maximized bool
+	activated bool
+	output    string
+}
+
+type wlrToplevel struct {
+	handle  uint32
+	pending wlrToplevelState
+	current wlrToplevelState
+	mapped  bool // got the first "done" event
+}
+
+type wlrBackend struct {
+	client      *wlClient
+	seat        uint32
+	outputs     []output
+	outputNames map[uint32]string // by wl_output object id
+	mu          sync.Mutex
+	toplevels   []*wlrToplevel
+	subscribers map[chan TaskChange]struct{}
+}
+
+func newWlrBackend() (*wlrBackend, error) {
+	client, err := wlConnect()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &wlrBackend{
+		client:      client,
+		outputNames: make(map[uint32]string),
+		subscribers: make(map[chan TaskChange]struct{}),
+	}
+
+	managers := client.findGlobals("zwlr_foreign_toplevel_manager_v1")
+	if len(managers) == 0 {
+		client.close(nil)
+		return nil, errors.New("compositor doesn't support zwlr_foreign_toplevel_manager_v1")
+	}
+
+	// activate requests need a seat
+	if seats := client.findGlobals("wl_seat"); len(seats) > 0 {
+		if b.seat, err = client.bind(seats[0], 1, nil); err != nil {
+			client.close(err)
+			return nil, err
+		}
+	}
+
+	b.bindOutputs()
+
+	if _, err := client.bind(managers[0], 3, b.managerEvent); err != nil {
+		client.close(err)
+		return nil, err
+	}
+	// wait for the initial toplevel events
+	if err := client.roundtrip(); err != nil {
+		client.close(err)
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// we need output names and logical positions (to map them onto gdk monitors) from xdg-output
+func (b *wlrBackend) bindOutputs() {
+	managers := b.client.findGlobals("zxdg_output_manager_v1")
+	if len(managers) == 0 {
+		log.Warn("Compositor doesn't support zxdg_output_manager_v1, outputs unknown")
+		return
+	}
+	manager, err := b.client.bind(managers[0], 3, nil)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+
+	outputs := make([]output, len(b.client.findGlobals("wl_output")))
+	wlOutputs := make([]uint32, len(outputs))
+	for i, g := range b.client.findGlobals("wl_output") {
+		wlOutput, err := b.client.bind(g, 1, nil)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		wlOutputs[i] = wlOutput
+		o := &outputs[i]
+		xdgOutput := b.client.newID(func(opcode uint16, m *wlMessage) {
+			switch opcode {
+			case 0: // logical_position
+				o.X, o.Y = int(m.readInt()), int(m.readInt())
+			case 3: // name
+				o.Name = m.readString()
+			}
+		})
+		if err := b.client.request(manager, 1, wlArgs{}.putUint(xdgOutput).putUint(wlOutput)); err != nil {
+			log.Warn(err)
+		}
+	}
+
+	if err := b.client.roundtrip(); err != nil {
+		log.Warn(err)
+	}
+	for i, o := range outputs {
+		if o.Name != "" {
+			b.outputs = append(b.outputs, o)
+			b.outputNames[wlOutputs[i]] = o.Name
+		}
+	}
+}
+
+func (b *wlrBackend) managerEvent(opcode uint16, m *wlMessage) {
+	switch opcode {
+	case 0: // toplevel
+		t := &wlrToplevel{handle: m.readUint()}
+		b.mu.Lock()
+		b.toplevels = append(b.toplevels, t)
+		b.mu.Unlock()
+		b.client.handle(t.handle, func(opcode uint16, m *wlMessage) {
+			b.toplevelEvent(t, opcode, m)
+		})
+	case 1: // finished
+		log.Warn("Foreign toplevel manager finished, no more task updates")
+	}
+}
+
+func (b *wlrBackend) toplevelEvent(t *wlrToplevel, opcode uint16, m *wlMessage) {
+	var change string
+
+	b.mu.Lock()
+	switch opcode {
+	case 0: // title
+		t.pending.title = m.readString()
+	case 1: // app_id
+		t.pending.appID = m.readString()
+	case 2: // output_enter
+		t.pending.output = b.outputNames[m.readUint()]
+	case 3: // output_leave
+		if b.outputNames[m.readUint()] == t.pending.output {
+			t.pending.output = ""
+		}
+	case 4: // state
+		states := m.readArray()
+		t.pending.minimized, t.pending.maximized, t.pending.activated = false, false, false
+		for i := 0; i+4 <= len(states); i += 4 {
+			switch states[i] {
+			case wlrToplevelStateMinimized:
+				t.pending.minimized = true
+			case wlrToplevelStateMaximized:
+				t.pending.maximized = true
+			case wlrToplevelStateActivated:
+				t.pending.activated = true
+			}
+		}
+	case 5: // done
+		if !t.mapped {
+			change = "new"
+		} else if t.pending.output != t.current.output {
+			change = "move"
+		} else if t.pending.title != t.current.title {
+			change = "title"
+		} else {
+			change = "state"
+		}
+		t.current = t.pending
+		t.mapped = true
+	case 6: // closed
+		for i, tl := range b.toplevels {
+			if tl == t {
+				b.toplevels = append(b.toplevels[:i], b.toplevels[i+1:]...)
+				break
+			}
+		}
+		if t.mapped {
+			change = "close"
+		}
+	}
+	subscribers := make([]chan TaskChange, 0, len(b.subscribers))
+	for ch := range b.subscribers {
+		subscribers = append(subscribers, ch)
+	}
+	b.mu.Unlock()
+
+	if opcode == 6 {
+		// destroy the handle
+		if err := b.client.request(t.handle, 7, nil); err != nil {
+			log.Warn(err)
+		}
+		b.client.forget(t.handle)
+	}
+
+	// w/o app_id it's not a task yet; it will be added on the first update that brings it
+	if change == "" || (change == "new" && t.current.appID == "") {
+		return
+	}
+	taskChange := TaskChange{Change: change, Task: &task{conID: int64(t.handle)}}
+	if change != "close" {
+		taskChange.Task = t.task()
+	}
+	for _, ch := range subscribers {
+		ch <- taskChange
+	}
+}
+
+func (t *wlrToplevel) task() *task {
+	return &task{
+		conID:     int64(t.handle),
+		ID:        t.current.appID,
+		Name:      t.current.title,
+		Minimized: t.current.minimized,
+		Maximized: t.current.maximized,
+		Focused:   t.current.activated,
+		Output:    t.current.output,
+	}
+}
+
+func (b *wlrBackend) findToplevel(conID int64) (*wlrToplevel, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, t := range b.toplevels {
+		if int64(t.handle) == conID {
+			return t, nil
+		}
+	}
+	return nil, errors.New("no such toplevel")
+}
+
+// returns tasks in the order of appearance; there are no workspaces, so the workspace name is always empty
+func (b *wlrBackend) ListTasks() ([]task, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var tasks []task
+	for _, t := range b.toplevels {
+		if !t.mapped || t.current.appID == "" {
+			continue
+		}
+		tasks = append(tasks, *t.task())
+	}
+	return tasks, "", nil
+}
+
+func (b *wlrBackend) Outputs() ([]output, error) {
+	return b.outputs, nil
+}
+
+func (b *wlrBackend) TaskEvents(ctx context.Context) (<-chan TaskChange, error) {
+	taskUpdateChannel := make(chan TaskChange, 1)
+
+	b.mu.Lock()
+	b.subscribers[taskUpdateChannel] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subscribers, taskUpdateChannel)
+		b.mu.Unlock()
+	}()
+
+	return taskUpdateChannel, nil
+}
+
+// no workspaces here, so the channel stays silent
+func (b *wlrBackend) WorkspaceEvents(ctx context.Context) (<-chan string, error) {
+	return make(chan string), nil
+}
+
+func (b *wlrBackend) Supports(f feature) bool {
+	return f == featureMinimize || f == featureMaximize
+}
+
+func (b *wlrBackend) FocusCon(conID int64) error {
+	t, err := b.findToplevel(conID)
+	if err != nil {
+		return err
+	}
+	if b.seat == 0 {
+		return errors.New("no seat to activate the toplevel with")
+	}
+	return b.client.request(t.handle, 4, wlArgs{}.putUint(b.seat))
+}
+
+func (b *wlrBackend) KillCon(conID int64) error {
+	t, err := b.findToplevel(conID)
+	if err != nil {
+		return err
+	}
+	return b.client.request(t.handle, 5, nil)
+}
+
+func (b *wlrBackend) SetMinimized(conID int64, minimized bool) error {
+	t, err := b.findToplevel(conID)
+	if err != nil {
+		return err
+	}
+	if minimized {
+		return b.client.request(t.handle, 2, nil)
+	}
+	return b.client.request(t.handle, 3, nil)
+}
+
+func (b *wlrBackend) SetMaximized(conID int64, maximized bool) error {
+	t, err := b.findToplevel(conID)
+	if err != nil {
+		return err
+	}
+	if maximized {
+		return b.client.request(t.handle, 0, nil)
+	}
+	return b.client.request(t.handle, 1, nil)
+}
+
+func (b *wlrBackend) MoveConToWorkspace(conID int64, wsNum int) error {
+	return errNotSupported
+}
+
+func (b *wlrBackend) FocusWorkspace(name string) error {
+	return errNotSupported
+}
+
+func (b *wlrBackend) Workspaces() ([]workspace, error) {
+	return nil, errNotSupported
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gotk3/gotk3/cairo"
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/gotk3/gotk3/pango"
+)
+
+/*
+The workspace switcher shows the focused workspace. Scrolling over it walks the list of existing workspaces,
+and a click focuses the one shown.
+*/
+func workspaceButton() *gtk.Button {
+	button, _ := gtk.ButtonNew()
+	button.AddEvents(int(gdk.SCROLL_MASK))
+
+	// the workspace shown, to be focused on click
+	var target workspace
+	show := func(ws workspace) {
+		target = ws
+		setWorkspaceContent(button, ws)
+	}
+
+	workspaces, err := backend.Workspaces()
+	if err != nil {
+		log.Warnf("Unable to list workspaces: %s", err)
+	}
+	if focused := focusedWorkspace(workspaces); focused != nil {
+		show(*focused)
+	}
+
+	// follow workspace changes as long as the button lives
+	ctx, cancel := context.WithCancel(context.Background())
+	button.Connect("destroy", cancel)
+
+	wsUpdateChannel, err := workspaceEvents.subscribe(ctx)
+	if err != nil {
+		log.Warnf("Unable to follow workspace changes: %s", err)
+	} else {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-wsUpdateChannel:
+				}
+
+				workspaces, err := backend.Workspaces()
+				if err != nil {
+					log.Warnf("Unable to list workspaces: %s", err)
+					continue
+				}
+				if focused := focusedWorkspace(workspaces); focused != nil {
+					ws := *focused
+					glib.TimeoutAdd(0, func() bool {
+						if ctx.Err() == nil {
+							show(ws)
+						}
+						return false
+					})
+				}
+			}
+		}()
+	}
+
+	button.Connect("clicked", func() {
+		if target.Name != "" {
+			focusWorkspace(target.Name)
+		}
+	})
+
+	button.Connect("enter-notify-event", cancelClose)
+
+	button.Connect("scroll-event", func(btn *gtk.Button, e *gdk.Event) bool {
+		event := gdk.EventScrollNewFromEvent(e)
+		step := 0
+		if event.Direction() == gdk.SCROLL_UP {
+			step = 1
+		} else if event.Direction() == gdk.SCROLL_DOWN {
+			step = -1
+		} else {
+			return false
+		}
+
+		workspaces := scrollWorkspaces()
+		if len(workspaces) == 0 {
+			return true
+		}
+		idx := 0
+		for i, ws := range workspaces {
+			if ws.Name == target.Name {
+				idx = (i + step + len(workspaces)) % len(workspaces)
+				break
+			}
+		}
+		show(workspaces[idx])
+		return true
+	})
+
+	return button
+}
+
+/*
+Workspaces to cycle through by scrolling over the switcher. With "-wsc output" these are the ones on the dock's
+output ("-o"; the focused output if not given), with "-wsc focused" the ones on the focused output. Workspaces
+not existing yet get created on the focused output, so they only show up if it's the one in use. With "-wse"
+empty workspaces are skipped, except for the focused one.
+*/
+func scrollWorkspaces() []workspace {
+	workspaces, err := knownWorkspaces()
+	if err != nil {
+		log.Warnf("Unable to list workspaces: %s", err)
+		return nil
+	}
+
+	focusedOutput := ""
+	if focused := focusedWorkspace(workspaces); focused != nil {
+		focusedOutput = focused.Output
+	}
+	output := ""
+	if *wsScroll == "output" {
+		output = *targetOutput
+	}
+	if (*wsScroll == "output" && output == "") || *wsScroll == "focused" {
+		output = focusedOutput
+	}
+
+	windows, _ := workspaceWindows()
+	var result []workspace
+	for _, ws := range workspaces {
+		if ws.Output == "" && output != "" && output != focusedOutput {
+			continue
+		}
+		if ws.Output != "" && output != "" && ws.Output != output {
+			continue
+		}
+		if *wsSkipEmpty && !ws.Focused && (ws.Output == "" || windows[ws.Name] == 0) {
+			continue
+		}
+		result = append(result, ws)
+	}
+	return result
+}
+
+// existing workspaces, plus numbered ones up to the "-w" value that don't exist yet, w/o an output then
+func knownWorkspaces() ([]workspace, error) {
+	workspaces, err := backend.Workspaces()
+	if err != nil {
+		return nil, err
+	}
+	for num := int64(1); num <= *numWS; num++ {
+		if !slices.ContainsFunc(workspaces, func(ws workspace) bool { return ws.Num == num }) {
+			workspaces = append(workspaces, workspace{Num: num, Name: strconv.FormatInt(num, 10)})
+		}
+	}
+	sortWorkspaces(workspaces)
+	return workspaces, nil
+}
+
+// numbers of windows, and if any of them is urgent, by workspace name; scratchpad tasks don't count
+func workspaceWindows() (map[string]int, map[string]bool) {
+	windows := make(map[string]int)
+	urgent := make(map[string]bool)
+	for _, t := range taskList.list() {
+		if t.WsName != "" {
+			windows[t.WsName]++
+			urgent[t.WsName] = urgent[t.WsName] || t.Urgent
+		}
+	}
+	return windows, urgent
+}
+
+// numbered workspaces to move windows to: the known ones, and the first free number for a new one
+func moveTargets() []int64 {
+	workspaces, err := knownWorkspaces()
+	if err != nil {
+		log.Warnf("Unable to list workspaces: %s", err)
+	}
+	var nums []int64
+	for _, ws := range workspaces {
+		if ws.Num > 0 {
+			nums = append(nums, ws.Num)
+		}
+	}
+	free := int64(1)
+	for slices.Contains(nums, free) {
+		free++
+	}
+	nums = append(nums, free)
+	slices.Sort(nums)
+	return nums
+}
+
+func focusedWorkspace(workspaces []workspace) *workspace {
+	for i := range workspaces {
+		if workspaces[i].Focused {
+			return &workspaces[i]
+		}
+	}
+	return nil
+}
+
+/*
+The workspace glyph gets drawn at runtime, so any number or name fits. The "workspace-indicator" CSS class
+sets the font, the text color and the background (background-color, background-image, border-radius).
+An SVG file named after the workspace in ~/.config/nwg-dock/workspaces/ (e.g. 3.svg or web.svg) overrides it.
+*/
+func workspaceContent(ws workspace) gtk.IWidget {
+	override := filepath.Join(configDirectory, "workspaces", fmt.Sprintf("%s.svg", ws.Name))
+	if pathExists(override) {
+		pixbuf, err := gdk.PixbufNewFromFileAtSize(override, imgSizeScaled, imgSizeScaled)
+		if err == nil {
+			image, _ := gtk.ImageNewFromPixbuf(pixbuf)
+			return image
+		}
+		log.Warnf("Unable to load %s: %s", override, err)
+	}
+	return workspaceIndicator(ws.Name)
+}
+
+func setWorkspaceContent(button *gtk.Button, ws workspace) {
+	if child, err := button.GetChild(); err == nil && child != nil {
+		button.Remove(child)
+	}
+	button.Add(workspaceContent(ws))
+	button.ShowAll()
+}
+
+func workspaceIndicator(text string) *gtk.DrawingArea {
+	area, _ := gtk.DrawingAreaNew()
+	area.SetSizeRequest(imgSizeScaled, imgSizeScaled)
+	ctx, _ := area.GetStyleContext()
+	ctx.AddClass("workspace-indicator")
+
+	area.Connect("draw", func(da *gtk.DrawingArea, cr *cairo.Context) bool {
+		w, h := float64(da.GetAllocatedWidth()), float64(da.GetAllocatedHeight())
+		style, _ := da.GetStyleContext()
+		state := style.GetState()
+		gtk.RenderBackground(style, cr, 0, 0, w, h)
+		renderBorder(style, state, cr, w, h)
+
+		layout := pango.CairoCreateLayout(cr)
+		if font, err := style.GetProperty("font", state); err == nil {
+			if fd, ok := font.(*pango.FontDescription); ok {
+				layout.SetFontDescription(fd)
+			}
+		}
+		layout.SetText(text, -1)
+		tw, th := layout.GetSize()
+		textW, textH := float64(tw)/float64(pango.SCALE), float64(th)/float64(pango.SCALE)
+
+		// long names get scaled down to fit, with some margin
+		scale := 1.0
+		if textW > w*0.9 {
+			scale = w * 0.9 / textW
+		}
+
+		color := style.GetColor(state)
+		cr.SetSourceRGBA(color.GetRed(), color.GetGreen(), color.GetBlue(), color.GetAlpha())
+		cr.Translate((w-textW*scale)/2, (h-textH*scale)/2)
+		cr.Scale(scale, scale)
+		pango.CairoShowLayout(cr, layout)
+		return false
+	})
+	return area
+}
+
+/*
+gotk3 lacks gtk_render_frame, so we draw the CSS border ourselves: all sides the way the top one is styled, with
+the border-radius of the corners.
+*/
+func renderBorder(style *gtk.StyleContext, state gtk.StateFlags, cr *cairo.Context, w, h float64) {
+	width, _ := style.GetProperty("border-top-width", state)
+	bw, ok := width.(int)
+	if !ok || bw <= 0 {
+		return
+	}
+	value, _ := style.GetProperty("border-top-color", state)
+	color, ok := value.(*gdk.RGBA)
+	if !ok {
+		return
+	}
+	radius := 0.0
+	if value, _ := style.GetProperty("border-radius", state); value != nil {
+		if r, ok := value.(int); ok {
+			radius = float64(r)
+		}
+	}
+
+	// the stroke is centered on the path, so the path runs half the width inside
+	half := float64(bw) / 2
+	r := max(min(radius, w/2, h/2)-half, 0)
+	x0, y0, x1, y1 := half, half, w-half, h-half
+
+	cr.Save()
+	defer cr.Restore()
+	cr.NewPath()
+	cr.Arc(x1-r, y0+r, r, -math.Pi/2, 0)
+	cr.Arc(x1-r, y1-r, r, 0, math.Pi/2)
+	cr.Arc(x0+r, y1-r, r, math.Pi/2, math.Pi)
+	cr.Arc(x0+r, y0+r, r, math.Pi, 3*math.Pi/2)
+	cr.ClosePath()
+	cr.SetSourceRGBA(color.GetRed(), color.GetGreen(), color.GetBlue(), color.GetAlpha())
+	cr.SetLineWidth(float64(bw))
+	cr.Stroke()
+}
+
+/*
+The workspace strip shows a button per workspace, instead of the switcher. If "-w" is given, workspaces 1 to
+its value are always there, the empty ones included. The button gets the "workspace" style class, plus "focused", "visible",
+"urgent" and "empty" classes as appropriate, and a "window-count" badge.
+*/
+func refreshWorkspaceStrip() {
+	if wsStripBox == nil {
+		return
+	}
+
+	workspaces, err := knownWorkspaces()
+	if err != nil {
+		log.Warnf("Unable to list workspaces: %s", err)
+		return
+	}
+	windows, urgent := workspaceWindows()
+
+	if children := wsStripBox.GetChildren(); children != nil {
+		children.Foreach(func(item interface{}) {
+			item.(*gtk.Widget).Destroy()
+		})
+	}
+	for _, ws := range workspaces {
+		ws.Urgent = ws.Urgent || urgent[ws.Name]
+		wsStripBox.PackStart(workspaceStripButton(ws, windows[ws.Name]), false, false, 0)
+	}
+	wsStripBox.ShowAll()
+}
+
+func workspaceStripButton(ws workspace, windows int) *gtk.Button {
+	button, _ := gtk.ButtonNew()
+	overlay, _ := gtk.OverlayNew()
+	overlay.Add(workspaceContent(ws))
+	button.Add(overlay)
+
+	ctx, _ := button.GetStyleContext()
+	ctx.AddClass("workspace")
+	if ws.Focused {
+		ctx.AddClass("focused")
+	} else if ws.Visible {
+		ctx.AddClass("visible")
+	}
+	if ws.Urgent {
+		ctx.AddClass("urgent")
+	}
+
+	tooltip := ws.Name
+	if windows > 0 {
+		badge, _ := gtk.LabelNew(strconv.Itoa(windows))
+		badge.SetHAlign(gtk.ALIGN_END)
+		badge.SetVAlign(gtk.ALIGN_START)
+		badgeCtx, _ := badge.GetStyleContext()
+		badgeCtx.AddClass("window-count")
+		overlay.AddOverlay(badge)
+		tooltip = fmt.Sprintf("%s (%v)", ws.Name, windows)
+	} else {
+		ctx.AddClass("empty")
+	}
+	button.SetTooltipText(tooltip)
+
+	button.Connect("clicked", func() {
+		focusWorkspace(ws.Name)
+	})
+	button.Connect("enter-notify-event", cancelClose)
+
+	return button
+}
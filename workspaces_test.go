@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestScrollWorkspaces(t *testing.T) {
+	setGlobal(t, &backend, Backend(&fakeBackend{
+		features: []feature{featureWorkspaces},
+		workspaces: []workspace{
+			{Num: 1, Name: "1", Output: "DP-1", Visible: true, Focused: true},
+			{Num: 3, Name: "3", Output: "HDMI-A-1", Visible: true},
+			{Name: "web", Output: "DP-1"},
+		},
+	}))
+	setGlobal(t, &taskList, &taskModel{})
+	taskList.reset([]task{
+		{conID: 1, ID: "foot", WsNum: 1, WsName: "1", Output: "DP-1"},
+		{conID: 2, ID: "firefox", WsNum: 3, WsName: "3", Output: "HDMI-A-1"},
+	}, "1")
+	setGlobal(t, numWS, 4)
+	setGlobal(t, wsSkipEmpty, false)
+	setGlobal(t, targetOutput, "")
+	setGlobal(t, wsScroll, "all")
+
+	for _, tc := range []struct {
+		scroll, output string
+		skipEmpty      bool
+		want           []string
+	}{
+		// workspaces up to "-w" that don't exist yet come in, w/o an output
+		{"all", "", false, []string{"1", "2", "3", "4", "web"}},
+		// those get created on the focused output, so they only count there
+		{"output", "HDMI-A-1", false, []string{"3"}},
+		{"output", "DP-1", false, []string{"1", "2", "4", "web"}},
+		// the focused output, w/o "-o"
+		{"output", "", false, []string{"1", "2", "4", "web"}},
+		{"focused", "HDMI-A-1", false, []string{"1", "2", "4", "web"}},
+		// empty ones are skipped, but the focused one
+		{"all", "", true, []string{"1", "3"}},
+	} {
+		*wsScroll, *targetOutput, *wsSkipEmpty = tc.scroll, tc.output, tc.skipEmpty
+		var names []string
+		for _, ws := range scrollWorkspaces() {
+			names = append(names, ws.Name)
+		}
+		if !equalStrings(names, tc.want...) {
+			t.Errorf("-wsc %s -o %q -wse=%v: %v, want %v", tc.scroll, tc.output, tc.skipEmpty, names, tc.want)
+		}
+	}
+}
+
+func equalStrings(a []string, b ...string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}